@@ -0,0 +1,129 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// AsyncDone is called when the replies to a batch of commands sent with AsyncPipeline.Send arrive, with the
+// indexes of the batch's commands in the sent slice, an error per command, and the batch's round trip latency
+type AsyncDone func(idxs []int, errs []error, latency time.Duration)
+
+type asyncBatch struct {
+	cmds  []Cmd
+	idxs  []int
+	start time.Time
+	done  AsyncDone
+}
+
+// asyncConn is a connection to a node that commands are sent on by the pipeline's owner, and whose replies are
+// received by its own goroutine
+type asyncConn struct {
+	node    *Node
+	conn    redis.Conn
+	pending chan *asyncBatch
+}
+
+// AsyncPipeline sends batches of commands pipelined on a single connection per node, without waiting for their
+// replies, with up to depth batches in flight on every connection. Sends block while a connection has depth
+// batches in flight. It is not safe for concurrent sends
+type AsyncPipeline struct {
+	c     *Client
+	depth int
+	conns map[*Node]*asyncConn
+	wg    sync.WaitGroup
+}
+
+// NewAsyncPipeline creates a pipeline with up to depth batches in flight per connection
+func (c *Client) NewAsyncPipeline(depth int) *AsyncPipeline {
+	return &AsyncPipeline{
+		c:     c,
+		depth: depth,
+		conns: map[*Node]*asyncConn{},
+	}
+}
+
+func (p *AsyncPipeline) conn(n *Node) *asyncConn {
+	ac, ok := p.conns[n]
+	if ok && ac.conn.Err() == nil {
+		return ac
+	}
+	if ok {
+		// the connection is broken: its receiver fails its pending batches and closes it, and a new one is opened
+		close(ac.pending)
+	}
+	// the receiver holds the batch whose replies it is reading, and the channel holds the others in flight
+	ac = &asyncConn{
+		node:    n,
+		conn:    n.Get(),
+		pending: make(chan *asyncBatch, p.depth-1),
+	}
+	p.conns[n] = ac
+	p.wg.Add(1)
+	go p.receive(ac)
+	return ac
+}
+
+// Send sends cmds to the nodes that serve their keys, as a batch per node. done is called once per batch, from
+// another goroutine, when its replies arrive. cmds must not be modified until then
+func (p *AsyncPipeline) Send(cmds []Cmd, done AsyncDone) {
+	groups := map[*Node][]int{}
+	for i, cmd := range cmds {
+		n := p.c.NodeForKey(cmd.Key)
+		groups[n] = append(groups[n], i)
+	}
+	for n, idxs := range groups {
+		ac := p.conn(n)
+		b := &asyncBatch{cmds: cmds, idxs: idxs, start: time.Now(), done: done}
+		// wait for a free slot before sending, so that at most depth batches are in flight
+		ac.pending <- b
+		for _, i := range idxs {
+			ac.conn.Send(cmds[i].Name, cmds[i].Args...)
+		}
+		// a failed flush breaks the connection, and the receiver fails the batch
+		ac.conn.Flush()
+	}
+}
+
+// receive reads the replies of a connection's batches in order. Commands that are redirected are retried with Do
+func (p *AsyncPipeline) receive(ac *asyncConn) {
+	defer p.wg.Done()
+	defer ac.conn.Close()
+	for b := range ac.pending {
+		errs := make([]error, len(b.idxs))
+		redirected := []int{}
+		failed := 0
+		for j := range b.idxs {
+			_, err := ac.conn.Receive()
+			if _, _, ok := redirect(err); ok {
+				redirected = append(redirected, j)
+				continue
+			}
+			if err != nil {
+				failed++
+			} else {
+				ac.node.Record(time.Since(b.start))
+			}
+			errs[j] = err
+		}
+		latency := time.Since(b.start)
+		if failed > 0 {
+			ac.node.RecordErrors(failed)
+		}
+		for _, j := range redirected {
+			cmd := b.cmds[b.idxs[j]]
+			_, errs[j] = p.c.Do(cmd.Key, cmd.Name, cmd.Args...)
+		}
+		b.done(b.idxs, errs, latency)
+	}
+}
+
+// Close waits for the replies of all the batches in flight, and closes the pipeline's connections
+func (p *AsyncPipeline) Close() {
+	for _, ac := range p.conns {
+		close(ac.pending)
+	}
+	p.wg.Wait()
+}
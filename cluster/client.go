@@ -0,0 +1,422 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedisLabs/rsbench/stats"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// maxRedirects is the number of MOVED/ASK redirects a command may follow before failing
+	maxRedirects = 5
+	// refreshInterval rate limits topology refreshes triggered by MOVED redirects
+	refreshInterval = time.Second
+)
+
+var ErrTooManyRedirects = errors.New("too many cluster redirects")
+
+// NodeStats are the requests sent to a single node
+type NodeStats struct {
+	Addr      string        `json:"addr"`
+	Requests  uint64        `json:"requests"`
+	Errors    uint64        `json:"errors"`
+	RPS       float64       `json:"rps"`
+	Latency   stats.Summary `json:"latency"`
+	ErrorRate float64       `json:"error_rate"`
+}
+
+// Node is a single redis server with its own connection pool and stats
+type Node struct {
+	Addr string
+	pool *redis.Pool
+
+	mu       sync.Mutex
+	requests uint64
+	errors   uint64
+	hist     *stats.Histogram
+	start    time.Time
+}
+
+func newNode(addr string, poolSize int) *Node {
+	return &Node{
+		Addr: addr,
+		pool: &redis.Pool{
+			MaxIdle:     poolSize,
+			MaxActive:   poolSize,
+			IdleTimeout: 240 * time.Second,
+			Wait:        true,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", addr)
+			},
+		},
+		hist:  stats.NewHistogram(),
+		start: time.Now(),
+	}
+}
+
+// Get returns a connection to the node from its pool
+func (n *Node) Get() redis.Conn {
+	return n.pool.Get()
+}
+
+// Record records a successful request that took d to complete. Pipelined requests take from the time their
+// batch was sent until their own reply arrives
+func (n *Node) Record(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.requests++
+	n.hist.Record(d)
+}
+
+// RecordErrors records failed requests
+func (n *Node) RecordErrors(requests int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.errors += uint64(requests)
+}
+
+func (n *Node) Stats() NodeStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return NodeStats{
+		Addr:      n.Addr,
+		Requests:  n.requests,
+		Errors:    n.errors,
+		RPS:       float64(n.requests) / time.Since(n.start).Seconds(),
+		Latency:   n.hist.Summary(),
+		ErrorRate: stats.Rate(n.errors, n.errors+n.requests),
+	}
+}
+
+// Client routes commands to the nodes of a redis cluster by their key's hash slot. If the hosts are not a
+// cluster, keyed commands are spread over them round robin
+type Client struct {
+	seeds    []string
+	poolSize int
+
+	mu          sync.RWMutex
+	nodes       map[string]*Node
+	masters     []*Node
+	slots       []*Node
+	clustered   bool
+	lastRefresh time.Time
+	next        uint64
+}
+
+// New creates a client for a comma separated list of hosts, and discovers the cluster topology from them.
+// poolSize is the connection pool size of every node
+func New(hosts string, poolSize int) (*Client, error) {
+	c := &Client{
+		seeds:    strings.Split(hosts, ","),
+		poolSize: poolSize,
+		nodes:    map[string]*Node{},
+	}
+	if err := c.Refresh(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Client) node(addr string) *Node {
+	if n, ok := c.nodes[addr]; ok {
+		return n
+	}
+	n := newNode(addr, c.poolSize)
+	c.nodes[addr] = n
+	return n
+}
+
+// clusterSlots reads the slot ranges of the cluster's masters from a single host. It returns a nil map
+// if the host is not part of a cluster
+func clusterSlots(host string) (map[[2]int]string, error) {
+	conn, err := redis.Dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		if rerr, ok := err.(redis.Error); ok && strings.Contains(string(rerr), "cluster support disabled") {
+			return nil, nil
+		}
+		return nil, err
+	}
+	seedHost, _, _ := net.SplitHostPort(host)
+	ret := map[[2]int]string{}
+	for _, r := range ranges {
+		// each range is: start slot, end slot, master [ip, port, ...], replicas...
+		vals, err := redis.Values(r, nil)
+		if err != nil || len(vals) < 3 {
+			return nil, fmt.Errorf("invalid CLUSTER SLOTS reply from %s", host)
+		}
+		start, _ := redis.Int(vals[0], nil)
+		end, _ := redis.Int(vals[1], nil)
+		master, err := redis.Values(vals[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, fmt.Errorf("invalid CLUSTER SLOTS reply from %s", host)
+		}
+		ip, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		if ip == "" {
+			ip = seedHost
+		}
+		ret[[2]int{start, end}] = net.JoinHostPort(ip, strconv.Itoa(port))
+	}
+	return ret, nil
+}
+
+// Refresh rediscovers the cluster topology from the first seed host that responds
+func (c *Client) Refresh() error {
+	var lastErr error
+	for _, seed := range c.seeds {
+		ranges, err := clusterSlots(seed)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.lastRefresh = time.Now()
+		c.masters = c.masters[:0]
+		if ranges == nil {
+			c.clustered = false
+			c.slots = nil
+			for _, h := range c.seeds {
+				c.masters = append(c.masters, c.node(h))
+			}
+			return nil
+		}
+
+		c.clustered = true
+		c.slots = make([]*Node, NumSlots)
+		seen := map[string]bool{}
+		for r, addr := range ranges {
+			n := c.node(addr)
+			for s := r[0]; s <= r[1]; s++ {
+				c.slots[s] = n
+			}
+			if !seen[addr] {
+				seen[addr] = true
+				c.masters = append(c.masters, n)
+			}
+		}
+		sort.Slice(c.masters, func(i, j int) bool { return c.masters[i].Addr < c.masters[j].Addr })
+		log.Printf("Discovered %d cluster masters: %v", len(c.masters), c.addrs())
+		return nil
+	}
+	return fmt.Errorf("could not discover cluster topology: %s", lastErr)
+}
+
+// Clustered returns true if the hosts are a redis cluster
+func (c *Client) Clustered() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.clustered
+}
+
+// Nodes returns the master nodes, sorted by address
+func (c *Client) Nodes() []*Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]*Node(nil), c.masters...)
+}
+
+func (c *Client) addrs() []string {
+	ret := make([]string, 0, len(c.masters))
+	for _, n := range c.masters {
+		ret = append(ret, n.Addr)
+	}
+	return ret
+}
+
+// Any returns a master node, round robin. Use it for commands that any node can coordinate
+func (c *Client) Any() *Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.masters[atomic.AddUint64(&c.next, 1)%uint64(len(c.masters))]
+}
+
+// NodeForKey returns the node that serves key's hash slot
+func (c *Client) NodeForKey(key string) *Node {
+	c.mu.RLock()
+	if !c.clustered {
+		c.mu.RUnlock()
+		return c.Any()
+	}
+	n := c.slots[Slot(key)]
+	c.mu.RUnlock()
+	if n == nil {
+		return c.Any()
+	}
+	return n
+}
+
+// redirect parses a MOVED or ASK error, returning the target address and whether it is an ASK redirect
+func redirect(err error) (addr string, ask bool, ok bool) {
+	rerr, isRedis := err.(redis.Error)
+	if !isRedis {
+		return "", false, false
+	}
+	parts := strings.Fields(string(rerr))
+	if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+		return "", false, false
+	}
+	return parts[2], parts[0] == "ASK", true
+}
+
+// moved updates the slot of key to addr after a MOVED redirect, and refreshes the whole topology in the
+// background, at most once every refreshInterval
+func (c *Client) moved(key, addr string) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.node(addr)
+	if c.clustered {
+		c.slots[Slot(key)] = n
+	}
+	if time.Since(c.lastRefresh) > refreshInterval {
+		c.lastRefresh = time.Now()
+		go func() {
+			if err := c.Refresh(); err != nil {
+				log.Println("Error refreshing cluster topology:", err)
+			}
+		}()
+	}
+	return n
+}
+
+func (c *Client) asked(addr string) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.node(addr)
+}
+
+// Do sends a command on key to the node that serves it, following MOVED and ASK redirects
+func (c *Client) Do(key, cmd string, args ...interface{}) (interface{}, error) {
+	reply, _, err := c.DoNode(c.NodeForKey(key), key, cmd, args...)
+	return reply, err
+}
+
+// DoNode sends a command on key to a given node, following MOVED and ASK redirects. It returns the node that
+// served the command, which differs from n if it was redirected
+func (c *Client) DoNode(n *Node, key, cmd string, args ...interface{}) (interface{}, *Node, error) {
+	asking := false
+	for i := 0; i < maxRedirects; i++ {
+		conn := n.Get()
+		if asking {
+			conn.Send("ASKING")
+		}
+		st := time.Now()
+		reply, err := conn.Do(cmd, args...)
+		conn.Close()
+		addr, ask, isRedirect := redirect(err)
+		if !isRedirect {
+			if err != nil {
+				n.RecordErrors(1)
+			} else {
+				n.Record(time.Since(st))
+			}
+			return reply, n, err
+		}
+		if ask {
+			n = c.asked(addr)
+		} else {
+			n = c.moved(key, addr)
+		}
+		asking = ask
+	}
+	return nil, n, ErrTooManyRedirects
+}
+
+// Cmd is a single command on a key
+type Cmd struct {
+	Key  string
+	Name string
+	Args []interface{}
+}
+
+// Pipeline sends commands to the nodes that serve their keys, pipelined on a single connection per node, and
+// returns an error per command. Commands that are redirected are retried with Do
+func (c *Client) Pipeline(cmds []Cmd) []error {
+	errs := make([]error, len(cmds))
+	groups := map[*Node][]int{}
+	for i, cmd := range cmds {
+		n := c.NodeForKey(cmd.Key)
+		groups[n] = append(groups[n], i)
+	}
+
+	wg := sync.WaitGroup{}
+	for n, idxs := range groups {
+		wg.Add(1)
+		go func(n *Node, idxs []int) {
+			defer wg.Done()
+			c.pipelineNode(n, cmds, idxs, errs)
+		}(n, idxs)
+	}
+	wg.Wait()
+	return errs
+}
+
+func (c *Client) pipelineNode(n *Node, cmds []Cmd, idxs []int, errs []error) {
+	conn := n.Get()
+	st := time.Now()
+	for _, i := range idxs {
+		conn.Send(cmds[i].Name, cmds[i].Args...)
+	}
+	if err := conn.Flush(); err != nil {
+		conn.Close()
+		n.RecordErrors(len(idxs))
+		for _, i := range idxs {
+			errs[i] = err
+		}
+		return
+	}
+	redirected := []int{}
+	failed := 0
+	for _, i := range idxs {
+		_, err := conn.Receive()
+		if _, _, ok := redirect(err); ok {
+			redirected = append(redirected, i)
+			continue
+		}
+		if err != nil {
+			failed++
+		} else {
+			n.Record(time.Since(st))
+		}
+		errs[i] = err
+	}
+	conn.Close()
+	if failed > 0 {
+		n.RecordErrors(failed)
+	}
+
+	for _, i := range redirected {
+		_, errs[i] = c.Do(cmds[i].Key, cmds[i].Name, cmds[i].Args...)
+	}
+}
+
+// NodeStats returns the stats of all the nodes that were sent requests, sorted by address
+func (c *Client) NodeStats() []NodeStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	ret := make([]NodeStats, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		if s := n.Stats(); s.Requests+s.Errors > 0 {
+			ret = append(ret, s)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Addr < ret[j].Addr })
+	return ret
+}
@@ -0,0 +1,32 @@
+package cluster
+
+import "strings"
+
+// NumSlots is the number of hash slots in a redis cluster
+const NumSlots = 16384
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum redis cluster uses for key hashing
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Slot returns the hash slot of a key. If the key contains a non empty {hash tag}, only the tag is hashed
+func Slot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % NumSlots)
+}
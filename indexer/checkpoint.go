@@ -0,0 +1,272 @@
+package indexer
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+)
+
+// DefaultCheckpointInterval is the interval of checkpoint writes
+const DefaultCheckpointInterval = 30 * time.Second
+
+// Checkpoint is the persisted progress of an indexing run: the files whose documents were all indexed, the
+// number of documents indexed from the start of every file that was being read, and the run's counters.
+// Offsets count documents rather than bytes, since compressed files can't be read from an offset
+type Checkpoint struct {
+	Completed  []string          `json:"completed"`
+	InProgress map[string]int    `json:"in_progress"`
+	Docs       uint64            `json:"docs"`
+	Bytes      uint64            `json:"bytes"`
+	Errors     map[string]uint64 `json:"errors"`
+	Retries    uint64            `json:"retries"`
+	Dropped    uint64            `json:"dropped"`
+	Elapsed    float64           `json:"elapsed"`
+	Time       time.Time         `json:"time"`
+}
+
+// LoadCheckpoint reads a checkpoint file
+func LoadCheckpoint(path string) (*Checkpoint, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	c := &Checkpoint{}
+	if err := json.NewDecoder(fp).Decode(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// save writes the checkpoint to a temporary file that replaces path, so that a crash never leaves a partial
+// checkpoint behind
+func (c *Checkpoint) save(path string) error {
+	tmp := path + ".tmp"
+	fp, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(fp)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(c); err != nil {
+		fp.Close()
+		return err
+	}
+	if err := fp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fileProgress tracks the documents of a single file. Documents are numbered in file order, and indexed counts
+// the documents from the start of the file that were all indexed, which can be less than the number of
+// documents indexed since they complete out of order
+type fileProgress struct {
+	path    string
+	read    int
+	eof     bool
+	indexed int
+	done    map[int]bool
+}
+
+// docOrigin is the file and number of a document
+type docOrigin struct {
+	f   *fileProgress
+	seq int
+}
+
+// Progress tracks which documents of which files were indexed. Documents are sent to the indexer and received
+// from it in the same order, which is how the indexer learns the origin of every document it receives
+type Progress struct {
+	sendMu sync.Mutex
+	recvMu sync.Mutex
+
+	mu        sync.Mutex
+	queue     []docOrigin
+	files     map[string]*fileProgress
+	completed []string
+	offsets   map[string]int
+}
+
+// NewProgress creates a progress tracker, resuming from a checkpoint if it is not nil
+func NewProgress(c *Checkpoint) *Progress {
+	p := &Progress{
+		files:   map[string]*fileProgress{},
+		offsets: map[string]int{},
+	}
+	if c != nil {
+		p.completed = append(p.completed, c.Completed...)
+		for path, n := range c.InProgress {
+			p.offsets[path] = n
+		}
+	}
+	return p
+}
+
+// open starts tracking a file. It returns nil if the file was completed by a previous run
+func (p *Progress) open(path string) *fileProgress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.completed {
+		if c == path {
+			return nil
+		}
+	}
+	offset := p.offsets[path]
+	delete(p.offsets, path)
+	f := &fileProgress{path: path, read: offset, indexed: offset, done: map[int]bool{}}
+	p.files[path] = f
+	return f
+}
+
+// offset returns the number of documents at the start of a file to skip, which a previous run indexed
+func (f *fileProgress) offset() int {
+	return f.indexed
+}
+
+// send sends the next document of a file to ch, unless stop is closed first
+func (p *Progress) send(f *fileProgress, doc redisearch.Document, ch chan<- redisearch.Document,
+	stop <-chan struct{}) bool {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	p.mu.Lock()
+	p.queue = append(p.queue, docOrigin{f, f.read})
+	f.read++
+	p.mu.Unlock()
+	select {
+	case ch <- doc:
+		return true
+	case <-stop:
+		// no other document was queued since, so the unsent one is last
+		p.mu.Lock()
+		p.queue = p.queue[:len(p.queue)-1]
+		f.read--
+		p.mu.Unlock()
+		return false
+	}
+}
+
+// receive receives the next document from ch along with its origin
+func (p *Progress) receive(ch <-chan redisearch.Document) (redisearch.Document, docOrigin, bool) {
+	p.recvMu.Lock()
+	defer p.recvMu.Unlock()
+	doc, ok := <-ch
+	if !ok {
+		return doc, docOrigin{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	o := p.queue[0]
+	p.queue = p.queue[1:]
+	return doc, o, true
+}
+
+// finished marks a file as read to its end
+func (p *Progress) finished(f *fileProgress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f.eof = true
+	p.complete(f)
+}
+
+// indexed marks a document as indexed, or as failed for good
+func (p *Progress) indexed(o docOrigin) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f := o.f
+	if o.seq != f.indexed {
+		f.done[o.seq] = true
+		return
+	}
+	f.indexed++
+	for f.done[f.indexed] {
+		delete(f.done, f.indexed)
+		f.indexed++
+	}
+	p.complete(f)
+}
+
+// complete moves a file to the completed files once it was read to its end and all its documents were indexed
+func (p *Progress) complete(f *fileProgress) {
+	if f.eof && f.indexed == f.read {
+		p.completed = append(p.completed, f.path)
+		delete(p.files, f.path)
+	}
+}
+
+// checkpoint returns the files completed and in progress
+func (p *Progress) checkpoint() *Checkpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := &Checkpoint{
+		Completed:  append([]string{}, p.completed...),
+		InProgress: map[string]int{},
+	}
+	sort.Strings(c.Completed)
+	// files of a previous run that were not opened yet keep their offsets
+	for path, n := range p.offsets {
+		c.InProgress[path] = n
+	}
+	for path, f := range p.files {
+		if f.indexed > 0 {
+			c.InProgress[path] = f.indexed
+		}
+	}
+	return c
+}
+
+// SetCheckpoint makes the indexer write the progress of the documents it receives, and its counters, to a
+// checkpoint file every interval and once indexing is done. If resume is not nil, the counters continue from it
+func (idx *Indexer) SetCheckpoint(path string, p *Progress, interval time.Duration, resume *Checkpoint) {
+	idx.checkpointPath = path
+	idx.progress = p
+	idx.checkpointInterval = interval
+	if resume != nil {
+		idx.counter = resume.Docs
+		idx.lastCount = resume.Docs
+		idx.totalDataSize = resume.Bytes
+		idx.errors.AddCounts(resume.Errors)
+		idx.retries = resume.Retries
+		idx.dropped = resume.Dropped
+		idx.resumedElapsed = time.Duration(resume.Elapsed * float64(time.Second))
+	}
+}
+
+// writeCheckpoint writes the progress and the counters. The counters include documents past the files'
+// offsets, so they overcount documents that are indexed again when resuming
+func (idx *Indexer) writeCheckpoint() {
+	c := idx.progress.checkpoint()
+	c.Docs = atomic.LoadUint64(&idx.counter)
+	c.Bytes = atomic.LoadUint64(&idx.totalDataSize)
+	c.Errors = idx.errors.Map()
+	c.Retries = atomic.LoadUint64(&idx.retries)
+	c.Dropped = atomic.LoadUint64(&idx.dropped)
+	c.Elapsed = time.Since(idx.start).Seconds()
+	c.Time = time.Now()
+	if err := c.save(idx.checkpointPath); err != nil {
+		log.Printf("Error writing checkpoint %s: %s", idx.checkpointPath, err)
+	}
+}
+
+// checkpointLoop writes a checkpoint every interval until stop is closed, then writes the last one and closes
+// done
+func (idx *Indexer) checkpointLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(idx.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			idx.writeCheckpoint()
+		case <-stop:
+			idx.writeCheckpoint()
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+)
+
+// sendDocs sends n documents of f through a buffered channel and receives them back, returning their origins
+func sendDocs(t *testing.T, p *Progress, f *fileProgress, n int) []docOrigin {
+	ch := make(chan redisearch.Document, n)
+	stop := make(chan struct{})
+	for i := 0; i < n; i++ {
+		if !p.send(f, redisearch.NewDocument("doc", 1), ch, stop) {
+			t.Fatalf("send %d failed", i)
+		}
+	}
+	origins := make([]docOrigin, n)
+	for i := range origins {
+		var ok bool
+		if _, origins[i], ok = p.receive(ch); !ok {
+			t.Fatalf("receive %d failed", i)
+		}
+	}
+	return origins
+}
+
+func TestProgressIndexed(t *testing.T) {
+	tests := []struct {
+		name string
+		// order in which the documents are indexed, -1 marks the end of the file
+		order         []int
+		wantIndexed   int
+		wantCompleted []string
+	}{
+		{"in order", []int{0, 1, 2}, 3, []string{}},
+		{"out of order", []int{2, 0, 1}, 3, []string{}},
+		{"gap", []int{0, 2}, 1, []string{}},
+		{"finished before the last ack", []int{0, 1, -1, 2}, 3, []string{"a"}},
+		{"finished after the last ack", []int{1, 2, 0, -1}, 3, []string{"a"}},
+		{"finished with a gap", []int{0, 2, -1}, 1, []string{}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewProgress(nil)
+			f := p.open("a")
+			origins := sendDocs(t, p, f, 3)
+			for _, seq := range tc.order {
+				if seq < 0 {
+					p.finished(f)
+				} else {
+					p.indexed(origins[seq])
+				}
+			}
+			c := p.checkpoint()
+			if f.indexed != tc.wantIndexed {
+				t.Errorf("indexed = %d, want %d", f.indexed, tc.wantIndexed)
+			}
+			if !reflect.DeepEqual(c.Completed, tc.wantCompleted) {
+				t.Errorf("completed = %v, want %v", c.Completed, tc.wantCompleted)
+			}
+			if _, ok := c.InProgress["a"]; ok == (len(tc.wantCompleted) > 0) {
+				t.Errorf("in progress = %v with completed %v", c.InProgress, c.Completed)
+			}
+		})
+	}
+}
+
+func TestProgressSendStopped(t *testing.T) {
+	p := NewProgress(nil)
+	f := p.open("a")
+	sendDocs(t, p, f, 2)
+
+	stop := make(chan struct{})
+	close(stop)
+	if p.send(f, redisearch.NewDocument("doc", 1), make(chan redisearch.Document), stop) {
+		t.Fatal("send succeeded on a stopped unbuffered channel")
+	}
+	if f.read != 2 || len(p.queue) != 0 {
+		t.Errorf("read = %d and queue = %d after a stopped send, want 2 and 0", f.read, len(p.queue))
+	}
+}
+
+func TestProgressResume(t *testing.T) {
+	p := NewProgress(nil)
+	a, b := p.open("a"), p.open("b")
+	for _, o := range sendDocs(t, p, a, 2) {
+		p.indexed(o)
+	}
+	p.finished(a)
+	origins := sendDocs(t, p, b, 3)
+	p.indexed(origins[0])
+	p.indexed(origins[2])
+
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+	if err := p.checkpoint().save(path); err != nil {
+		t.Fatal(err)
+	}
+	c, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Completed, []string{"a"}) || !reflect.DeepEqual(c.InProgress, map[string]int{"b": 1}) {
+		t.Fatalf("checkpoint = %v %v, want [a] map[b:1]", c.Completed, c.InProgress)
+	}
+
+	p = NewProgress(c)
+	if p.open("a") != nil {
+		t.Error("completed file opened again")
+	}
+	// an unopened file keeps its offset in later checkpoints
+	if n := p.checkpoint().InProgress["b"]; n != 1 {
+		t.Errorf("unopened offset = %d, want 1", n)
+	}
+	f := p.open("b")
+	if f.offset() != 1 || f.read != 1 {
+		t.Errorf("offset = %d and read = %d, want 1 and 1", f.offset(), f.read)
+	}
+	// documents of the resumed file are numbered from its offset
+	if o := sendDocs(t, p, f, 1)[0]; o.seq != 1 {
+		t.Errorf("first resumed document seq = %d, want 1", o.seq)
+	}
+}
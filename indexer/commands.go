@@ -0,0 +1,36 @@
+package indexer
+
+import (
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
+)
+
+// addCmd builds the FT.ADD command for a document, keyed by the document id so it can be routed to the
+// cluster node that owns the document's hash slot. If condition is set, a replace only happens if the
+// existing document matches it
+func addCmd(index string, opts redisearch.IndexingOptions, condition string, doc redisearch.Document) cluster.Cmd {
+	args := []interface{}{index, doc.Id, doc.Score}
+	if opts.NoSave {
+		args = append(args, "NOSAVE")
+	}
+	if opts.Replace {
+		args = append(args, "REPLACE")
+		if opts.Partial {
+			args = append(args, "PARTIAL")
+		}
+		if condition != "" {
+			args = append(args, "IF", condition)
+		}
+	}
+	if opts.Language != "" {
+		args = append(args, "LANGUAGE", opts.Language)
+	}
+	if doc.Payload != nil {
+		args = append(args, "PAYLOAD", doc.Payload)
+	}
+	args = append(args, "FIELDS")
+	for k, v := range doc.Properties {
+		args = append(args, k, fieldValue(v))
+	}
+	return cluster.Cmd{Key: doc.Id, Name: "FT.ADD", Args: args}
+}
@@ -0,0 +1,222 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+)
+
+// stopwords are RediSearch's default stopwords, which are not indexed and so make no useful query terms
+var stopwords = map[string]bool{
+	"a": true, "is": true, "the": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "but": true, "by": true, "for": true, "if": true, "in": true, "into": true, "it": true,
+	"no": true, "not": true, "of": true, "on": true, "or": true, "such": true, "that": true, "their": true,
+	"then": true, "there": true, "these": true, "they": true, "this": true, "to": true, "was": true,
+	"will": true, "with": true,
+}
+
+// tokenize splits text into lower case terms the way the default tokenizer roughly does, leaving out
+// stopwords and single characters. Like the tokenizer, it keeps underscores in terms
+func tokenize(text string) []string {
+	ret := []string{}
+	for _, t := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	}) {
+		if len(t) > 1 && !stopwords[t] {
+			ret = append(ret, t)
+		}
+	}
+	return ret
+}
+
+// TermFreq is a term or tag value and the number of sampled documents it appears in
+type TermFreq struct {
+	Term string
+	Docs int
+}
+
+// CorpusModel samples the documents being indexed to model their terms and field values: the document
+// frequency of terms, phrases, the ranges of numeric fields and the frequencies of tag values. It is safe for
+// concurrent use
+type CorpusModel struct {
+	every    uint64
+	count    uint64
+	maxTerms int
+
+	text    map[string]bool
+	numeric map[string]bool
+	tags    map[string]byte
+
+	mu         sync.Mutex
+	rng        *rand.Rand
+	docs       int
+	df         map[string]int
+	phrases    []string
+	numPhrases int
+	ranges     map[string][2]float64
+	tagValues  map[string]map[string]int
+}
+
+// maxPhrases is the size of the random sample of phrases
+const maxPhrases = 10000
+
+// NewCorpusModel creates a model of the documents of the given schema that samples one in every every
+// documents, and counts up to maxTerms distinct terms and values of every tag field
+func NewCorpusModel(sc *redisearch.Schema, every, maxTerms int) *CorpusModel {
+	m := &CorpusModel{
+		every:     uint64(every),
+		maxTerms:  maxTerms,
+		text:      map[string]bool{},
+		numeric:   map[string]bool{},
+		tags:      map[string]byte{},
+		rng:       rand.New(rand.NewSource(1)),
+		df:        map[string]int{},
+		ranges:    map[string][2]float64{},
+		tagValues: map[string]map[string]int{},
+	}
+	for _, f := range sc.Fields {
+		switch f.Type {
+		case redisearch.TextField:
+			m.text[f.Name] = true
+		case redisearch.NumericField:
+			m.numeric[f.Name] = true
+		case redisearch.TagField:
+			sep := byte(',')
+			if opts, ok := f.Options.(redisearch.TagFieldOptions); ok && opts.Separator != 0 {
+				sep = opts.Separator
+			}
+			m.tags[f.Name] = sep
+			m.tagValues[f.Name] = map[string]int{}
+		}
+	}
+	return m
+}
+
+// Observe adds a document to the model, if it is sampled
+func (m *CorpusModel) Observe(doc redisearch.Document) {
+	if atomic.AddUint64(&m.count, 1)%m.every != 0 {
+		return
+	}
+	// tokenize outside of the lock
+	terms := map[string]bool{}
+	var phrases []string
+	for k, v := range doc.Properties {
+		if !m.text[k] {
+			continue
+		}
+		tokens := tokenize(fmt.Sprint(v))
+		for i, t := range tokens {
+			terms[t] = true
+			if i > 0 {
+				phrases = append(phrases, tokens[i-1]+" "+t)
+			}
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.docs++
+	for t := range terms {
+		if _, ok := m.df[t]; ok || len(m.df) < m.maxTerms {
+			m.df[t]++
+		}
+	}
+	for _, p := range phrases {
+		m.numPhrases++
+		if len(m.phrases) < maxPhrases {
+			m.phrases = append(m.phrases, p)
+		} else if n := m.rng.Intn(m.numPhrases); n < maxPhrases {
+			m.phrases[n] = p
+		}
+	}
+	for k, v := range doc.Properties {
+		if m.numeric[k] {
+			f, err := strconv.ParseFloat(fmt.Sprint(v), 64)
+			if err != nil {
+				continue
+			}
+			r, ok := m.ranges[k]
+			if !ok {
+				r = [2]float64{f, f}
+			}
+			m.ranges[k] = [2]float64{math.Min(r[0], f), math.Max(r[1], f)}
+		} else if sep, ok := m.tags[k]; ok {
+			values := m.tagValues[k]
+			for _, t := range strings.Split(fmt.Sprint(v), string(sep)) {
+				if t = strings.TrimSpace(t); t == "" {
+					continue
+				}
+				if _, ok := values[t]; ok || len(values) < m.maxTerms {
+					values[t]++
+				}
+			}
+		}
+	}
+}
+
+// Docs returns the number of sampled documents
+func (m *CorpusModel) Docs() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.docs
+}
+
+func sortedFreqs(counts map[string]int) []TermFreq {
+	ret := make([]TermFreq, 0, len(counts))
+	for t, n := range counts {
+		ret = append(ret, TermFreq{t, n})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Docs != ret[j].Docs {
+			return ret[i].Docs > ret[j].Docs
+		}
+		return ret[i].Term < ret[j].Term
+	})
+	return ret
+}
+
+// Terms returns the sampled terms, most frequent first
+func (m *CorpusModel) Terms() []TermFreq {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return sortedFreqs(m.df)
+}
+
+// Phrases returns a random sample of pairs of adjacent terms
+func (m *CorpusModel) Phrases() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.phrases...)
+}
+
+// NumericRanges returns the observed range of every numeric field
+func (m *CorpusModel) NumericRanges() map[string][2]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make(map[string][2]float64, len(m.ranges))
+	for k, r := range m.ranges {
+		ret[k] = r
+	}
+	return ret
+}
+
+// TagValues returns the observed values of every tag field, most frequent first
+func (m *CorpusModel) TagValues() map[string][]TermFreq {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make(map[string][]TermFreq, len(m.tagValues))
+	for k, values := range m.tagValues {
+		if len(values) > 0 {
+			ret[k] = sortedFreqs(values)
+		}
+	}
+	return ret
+}
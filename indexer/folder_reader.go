@@ -3,11 +3,15 @@ package indexer
 import (
 	"compress/bzip2"
 	"compress/gzip"
+	"errors"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/RedisLabs/redisearch-go/redisearch"
@@ -18,14 +22,25 @@ type FolderReader struct {
 	opener      DocumentReaderOpener
 	folder      string
 	pattern     string
+	exclude     map[string]bool
+	sampling    Sampling
+	progress    *Progress
+	stopch      chan struct{}
+	once        sync.Once
 }
 
+// sampledFileBuffer is the number of documents read ahead of every file being drained, when sampling
+const sampledFileBuffer = 1000
+
+// NewFolderReader creates a reader of the files under path whose names match pattern, a file name pattern or a
+// comma separated list of them
 func NewFolderReader(path, pattern string, concurrency int, opener DocumentReaderOpener) *FolderReader {
 	return &FolderReader{
 		concurrency: concurrency,
 		opener:      opener,
 		folder:      path,
 		pattern:     pattern,
+		stopch:      make(chan struct{}),
 	}
 }
 
@@ -52,56 +67,122 @@ func (fr *FolderReader) processPath(path string, pattern string, ch chan string,
 	if file.IsDir() {
 		fr.processDir(path, pattern, ch, level)
 	} else {
-		if match, err := filepath.Match(pattern, file.Name()); err == nil {
-			// If there is only one file, ignore the extension!
-			if level == 0 || match {
-				log.Println("Found file", path)
-				ch <- path
+		match := false
+		for _, p := range strings.Split(pattern, ",") {
+			m, err := filepath.Match(p, file.Name())
+			if err != nil {
+				panic(err)
 			}
-		} else {
-			panic(err)
+			match = match || m
+		}
+		// If there is only one file, ignore the extension!
+		if (level == 0 || match) && !fr.excluded(path) {
+			log.Println("Found file", path)
+			ch <- path
 		}
 	}
 }
 
-func (fr *FolderReader) loop(ch chan<- redisearch.Document, in <-chan string, wg *sync.WaitGroup) {
-	for f := range in {
-		// send something to the waitch that will be consumed by the workers
-		log.Println("Opening", f)
-		var fp io.Reader
-		var err error
-		if fp, err = os.Open(f); err != nil {
-			log.Println("Error opening ", f, ":", err)
-		} else {
-			ext := filepath.Ext(f)
-			var compressedReader io.Reader
-			switch ext {
-			case "bz2":
-				compressedReader = bzip2.NewReader(fp)
-			case "gz":
-				compressedReader, err = gzip.NewReader(fp)
-				if err != nil {
-					panic("Couldn't open gzip reader!")
-				}
+// Exclude makes the reader skip the given files, e.g. files that the benchmark reads or writes itself next to
+// the documents
+func (fr *FolderReader) Exclude(paths ...string) {
+	if fr.exclude == nil {
+		fr.exclude = map[string]bool{}
+	}
+	for _, p := range paths {
+		if p == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			fr.exclude[abs] = true
+		}
+	}
+}
+
+func (fr *FolderReader) excluded(path string) bool {
+	abs, err := filepath.Abs(path)
+	return err == nil && fr.exclude[abs]
+}
+
+// readFile sends the documents of a file to ch, and returns false if the reader was stopped
+func (fr *FolderReader) readFile(f string, ch chan<- redisearch.Document) bool {
+	var progress *fileProgress
+	if fr.progress != nil {
+		if progress = fr.progress.open(f); progress == nil {
+			log.Println("Skipping completed file", f)
+			return true
+		}
+	}
+	log.Println("Opening", f)
+	var fp io.Reader
+	file, err := os.Open(f)
+	if err != nil {
+		log.Println("Error opening ", f, ":", err)
+		return true
+	}
+	defer file.Close()
+	fp = file
+	ext := filepath.Ext(f)
+	var compressedReader io.Reader
+	switch ext {
+	case "bz2":
+		compressedReader = bzip2.NewReader(fp)
+	case "gz":
+		compressedReader, err = gzip.NewReader(fp)
+		if err != nil {
+			panic("Couldn't open gzip reader!")
+		}
+	}
+	if compressedReader != nil {
+		fp = compressedReader
+	}
+	dr, err := fr.opener.Open(fp)
+	if err != nil {
+		log.Println(err)
+		return true
+	}
+	skip := 0
+	if progress != nil && progress.offset() > 0 {
+		skip = progress.offset()
+		log.Printf("Skipping the first %d documents of %s", skip, f)
+	}
+	for err == nil {
+		var doc redisearch.Document
+		if doc, err = dr.Read(); err == nil {
+			if skip > 0 {
+				skip--
+				continue
 			}
-			if compressedReader != nil {
-				fp = compressedReader
+			if progress != nil {
+				if !fr.progress.send(progress, doc, ch, fr.stopch) {
+					return false
+				}
+				continue
 			}
-			dr, err := fr.opener.Open(fp)
-			if err != nil {
-				log.Println(err)
-				return
+			select {
+			case <-fr.stopch:
+				return false
+			case ch <- doc:
 			}
-			for err == nil {
+		}
+	}
+	if err != io.EOF {
+		// the file is left in progress, so that resuming reads it again from its offset
+		log.Printf("Error reading %s: %s", f, err)
+		return true
+	}
+	if progress != nil {
+		fr.progress.finished(progress)
+	}
+	log.Println("Finished reading", f)
+	return true
+}
 
-				doc, e := dr.Read()
-				if e == nil {
-					ch <- doc
-				}
-				err = e
-			}
+func (fr *FolderReader) loop(ch chan<- redisearch.Document, in <-chan string, wg *sync.WaitGroup) {
+	for f := range in {
+		if !fr.readFile(f, ch) {
+			break
 		}
-		log.Println("Finished reading", f)
 	}
 	log.Println("Reader exiting")
 	wg.Done()
@@ -109,6 +190,13 @@ func (fr *FolderReader) loop(ch chan<- redisearch.Document, in <-chan string, wg
 }
 
 func (fr *FolderReader) Start(ch chan<- redisearch.Document) error {
+	if fr.sampling.Enabled() {
+		if fr.progress != nil {
+			return errors.New("sampled reads can't be checkpointed")
+		}
+		fr.startSampled(ch)
+		return nil
+	}
 	filech := make(chan string)
 	wg := sync.WaitGroup{}
 	wg.Add(1)
@@ -132,6 +220,104 @@ func (fr *FolderReader) Start(ch chan<- redisearch.Document) error {
 	return nil
 }
 
-func (fr *FolderReader) Stop() {
+// SetSampling makes the reader read its files in a stable order, and send only the sampled documents
+func (fr *FolderReader) SetSampling(s Sampling) {
+	fr.sampling = s
+}
+
+// SetProgress makes the reader track the progress of the files it reads, skipping the files and the documents a
+// previous run completed. It can't be used with sampling
+func (fr *FolderReader) SetProgress(p *Progress) {
+	fr.progress = p
+}
+
+// Files returns the paths of the files to read, by path or shuffled by the file seed
+func (fr *FolderReader) Files() []string {
+	ch := make(chan string)
+	go func() {
+		fr.processPath(fr.folder, fr.pattern, ch, 0)
+		close(ch)
+	}()
+	ret := []string{}
+	for f := range ch {
+		ret = append(ret, f)
+	}
+	sort.Strings(ret)
+	if fr.sampling.FileSeed != 0 {
+		rng := rand.New(rand.NewSource(fr.sampling.FileSeed))
+		rng.Shuffle(len(ret), func(i, j int) {
+			ret[i], ret[j] = ret[j], ret[i]
+		})
+	}
+	return ret
+}
+
+// startSampled reads files concurrently, but sends their documents in file order, so that sampling selects the
+// same documents on every run. Every file is read into its own buffer, which is drained in turn
+func (fr *FolderReader) startSampled(ch chan<- redisearch.Document) {
+	type fileJob struct {
+		path string
+		docs chan redisearch.Document
+	}
+	jobs := make(chan fileJob)
+	order := make(chan chan redisearch.Document, fr.concurrency)
+	go func() {
+		defer close(jobs)
+		defer close(order)
+		for _, f := range fr.Files() {
+			docs := make(chan redisearch.Document, sampledFileBuffer)
+			// the buffer is queued for draining before the file is read, so files are drained in order
+			select {
+			case order <- docs:
+			case <-fr.stopch:
+				return
+			}
+			select {
+			case jobs <- fileJob{f, docs}:
+			case <-fr.stopch:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < fr.concurrency; i++ {
+		go func() {
+			for job := range jobs {
+				ok := fr.readFile(job.path, job.docs)
+				close(job.docs)
+				if !ok {
+					return
+				}
+			}
+		}()
+	}
 
+	go func() {
+		sp := newSampler(fr.sampling)
+		more := true
+		for docs := range order {
+			for doc := range docs {
+				keep, m := sp.keep()
+				if keep {
+					ch <- doc
+				}
+				if more = m; !more {
+					break
+				}
+			}
+			if !more {
+				break
+			}
+		}
+		// stop the readers of the files that are no longer needed
+		fr.Stop()
+		log.Printf("Sampled %d of %d documents read", sp.kept, sp.read)
+		close(ch)
+	}()
+}
+
+func (fr *FolderReader) Stop() {
+	fr.once.Do(func() {
+		close(fr.stopch)
+	})
 }
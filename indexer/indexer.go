@@ -2,15 +2,18 @@ package indexer
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
 	"log"
+	"math/rand"
 	"os"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/RedisLabs/rsbench/stats"
 )
 
 type DocumentParser interface {
@@ -43,7 +46,9 @@ func (f DocumentReaderOpenerFunc) Open(r io.Reader) (DocumentReader, error) {
 }
 
 type Indexer struct {
+	name         string
 	client       *redisearch.Client
+	cc           *cluster.Client
 	concurrency  int
 	chunkSize    int
 	ch           chan redisearch.Document
@@ -52,72 +57,258 @@ type Indexer struct {
 	wg           sync.WaitGroup
 	counter      uint64
 	lastCount    uint64
-	totalLatency uint64
 	lastDataSize uint64
 	lastTime     time.Time
 	cw           *csv.Writer
+	errors       stats.ErrorCounts
+	profile      RateProfile
+	limiter      *limiter
+	phaseDocs    uint64
+	ready        chan struct{}
+	done         int32
+
+	mix           *OpMix
+	noSave        bool
+	partialFields []string
+	condition     string
+	ids           *idSample
+	opStats       [numOps]*opStats
+	start         time.Time
+	elapsed       time.Duration
+	storage       Storage
+	prefix        string
+	numeric       map[string]bool
+	vectors       []VectorField
+	lag           *lagProbe
+	pipelineDepth int
+	corpus        *CorpusModel
+
+	totalDataSize  uint64
+	reportInterval time.Duration
+	histMu         sync.Mutex
+	hist           *stats.Histogram
+	intervalHist   *stats.Histogram
+
+	serverMu        sync.Mutex
+	server          *ServerStats
+	baseline        *ServerStats
+	serverErrLogged bool
+
+	indexMode IndexMode
+	forceDrop bool
+
+	progress           *Progress
+	checkpointPath     string
+	checkpointInterval time.Duration
+	resumedElapsed     time.Duration
+
+	retryPolicy RetryPolicy
+	retries     uint64
+	dropped     uint64
+	deadLetters *deadLetters
 }
 
-func (idx *Indexer) loop() {
+// prepare picks the operation of every document of a chunk, replacing the ids of updates and deletes with
+// sampled ids of indexed documents if id sampling is set. It returns the indexes of the chunk by operation
+func (idx *Indexer) prepare(rng *rand.Rand, chunk []redisearch.Document) [numOps][]int {
+	var byOp [numOps][]int
+	for i := range chunk {
+		op := OpAdd
+		if idx.mix != nil {
+			op = idx.mix.pick(rng)
+		}
+		if op.needsExisting() && idx.ids != nil {
+			id, ok := idx.ids.pick(op.deletes())
+			if !ok {
+				// nothing was indexed yet to update or delete
+				op = OpAdd
+			} else {
+				chunk[i].Id = id
+			}
+		}
+		byOp[op] = append(byOp[op], i)
+	}
+	return byOp
+}
 
-	st := time.Now()
-	N := idx.chunkSize
-	chunk := make([]redisearch.Document, N)
-	dx := 0
-	for doc := range idx.ch {
-		if doc.Id == "" {
+// complete processes the replies to a batch of documents sent with the same operation
+func (idx *Indexer) complete(op Op, docs []redisearch.Document, cmds []cluster.Cmd, origins []docOrigin,
+	errs []error, latency time.Duration) {
+	attempts := idx.retryFailed(op, cmds, errs)
+	acked := time.Now()
+	var totalSz uint64
+	indexed := 0
+	for i, err := range errs {
+		doc := docs[i]
+		if origins != nil {
+			idx.progress.indexed(origins[i])
+		}
+		if err != nil {
+			class := idx.errors.Add(err, 1)
+			idx.opStats[op].errors.Add(err, 1)
+			idx.opStats[op].drop()
+			atomic.AddUint64(&idx.dropped, 1)
+			log.Printf("Error indexing %s (%s, %s) after %d attempts: %s\n", doc.Id, op, class, attempts[i], err)
+			if idx.deadLetters != nil {
+				if e := idx.deadLetters.write(op, doc, err, class.String(), attempts[i]); e != nil {
+					log.Printf("Error writing dead letter %s: %s\n", doc.Id, e)
+				}
+			}
 			continue
 		}
-		chunk[dx] = doc
-		dx++
-		if dx == N {
-			dx = 0
+		if idx.ids != nil && (op == OpAdd || op == OpReplace) {
+			idx.ids.add(doc.Id)
+		}
+		// updated documents are searchable before the update, so only new documents have a lag
+		if idx.lag != nil && op == OpAdd {
+			idx.lag.written(cmds[i].Key, acked)
+		}
+		totalSz += uint64(doc.EstimateSize())
+		indexed++
+	}
+	if indexed == 0 {
+		return
+	}
+	idx.opStats[op].record(indexed, latency)
+	idx.recordLatency(latency)
+	atomic.AddUint64(&idx.lastDataSize, totalSz)
+	atomic.AddUint64(&idx.totalDataSize, totalSz)
+	atomic.AddUint64(&idx.counter, uint64(indexed))
+}
+
+// receive returns the next document read, along with its origin if progress is tracked
+func (idx *Indexer) receive() (redisearch.Document, docOrigin, bool) {
+	if idx.progress != nil {
+		return idx.progress.receive(idx.ch)
+	}
+	doc, ok := <-idx.ch
+	return doc, docOrigin{}, ok
+}
 
+// send sends a chunk of documents. Every operation is pipelined separately, so that its latency is measured on
+// its own. Pipelined batches with writes to retry are completed on retries, so that backing off doesn't hold up
+// the replies of the other batches in flight
+func (idx *Indexer) send(rng *rand.Rand, pipe *cluster.AsyncPipeline, retries chan<- func(),
+	chunk []redisearch.Document, origins []docOrigin) {
+	if idx.limiter != nil {
+		idx.limiter.Wait(len(chunk))
+	}
+	for o, is := range idx.prepare(rng, chunk) {
+		if len(is) == 0 {
+			continue
+		}
+		op := Op(o)
+		docs := make([]redisearch.Document, len(is))
+		cmds := make([]cluster.Cmd, len(is))
+		var dorigins []docOrigin
+		if origins != nil {
+			dorigins = make([]docOrigin, len(is))
+		}
+		for j, i := range is {
+			docs[j] = chunk[i]
+			cmds[j] = idx.opCmd(op, chunk[i])
+			if origins != nil {
+				dorigins[j] = origins[i]
+			}
+		}
+		if pipe == nil {
 			t1 := time.Now()
-			if err := idx.client.IndexOptions(redisearch.IndexingOptions{NoSave: true}, chunk...); err != nil {
-				log.Printf("Error indexing %#v %s: %s\n", chunk, doc.Id, err)
-				continue
+			errs := idx.cc.Pipeline(cmds)
+			idx.complete(op, docs, cmds, dorigins, errs, time.Since(t1))
+			continue
+		}
+		pipe.Send(cmds, func(js []int, errs []error, latency time.Duration) {
+			bdocs := make([]redisearch.Document, len(js))
+			bcmds := make([]cluster.Cmd, len(js))
+			var borigins []docOrigin
+			if dorigins != nil {
+				borigins = make([]docOrigin, len(js))
 			}
-			latency := time.Since(t1)
-			var totalSz uint64
-			for i := range chunk {
-				totalSz += uint64(chunk[i].EstimateSize())
+			for k, j := range js {
+				bdocs[k], bcmds[k] = docs[j], cmds[j]
+				if dorigins != nil {
+					borigins[k] = dorigins[j]
+				}
 			}
-			dataSize := atomic.AddUint64(&idx.lastDataSize, uint64(totalSz))
-			totalLatency := atomic.AddUint64(&idx.totalLatency, uint64(latency))
-			if x := atomic.AddUint64(&idx.counter, uint64(N)); x%1000 == 0 && time.Since(idx.lastTime) > 5*time.Second {
-				elapsed := time.Since(st)
-				currentTime := time.Since(idx.lastTime)
-				avgLatency := time.Duration(totalLatency/idx.counter).Seconds() * 1000
-				dataRate := (float64(dataSize) / currentTime.Seconds()) / (1024 * 1024)
-
-				idx.cw.Write([]string{
-					strconv.FormatFloat(elapsed.Seconds(), 'f', 2, 32),
-					strconv.FormatUint(x, 10),
-					strconv.FormatFloat(float64(x-idx.lastCount)/currentTime.Seconds(), 'f', 2, 32),
-					strconv.FormatFloat(avgLatency, 'f', 2, 32),
-					strconv.FormatFloat(dataRate, 'f', 2, 32),
-				})
-				idx.cw.Flush()
-				log.Printf("Indexed %d docs in %v, rate %.02fdocs/sec, latency %.02fms, dataRate: %.02fMB/s", x, elapsed,
-					float64(x-idx.lastCount)/currentTime.Seconds(),
-					avgLatency, dataRate)
-
-				atomic.StoreUint64(&idx.lastCount, x)
-				atomic.StoreUint64(&idx.lastDataSize, 0)
-				idx.lastTime = time.Now()
-
+			if idx.needsRetry(errs) {
+				retries <- func() { idx.complete(op, bdocs, bcmds, borigins, errs, latency) }
+				return
 			}
+			idx.complete(op, bdocs, bcmds, borigins, errs, latency)
+		})
+	}
+}
+
+func (idx *Indexer) loop() {
 
+	N := idx.chunkSize
+	chunk := make([]redisearch.Document, 0, N)
+	var origins []docOrigin
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	var pipe *cluster.AsyncPipeline
+	var retries chan func()
+	retried := make(chan struct{})
+	if idx.pipelineDepth > 1 {
+		pipe = idx.cc.NewAsyncPipeline(idx.pipelineDepth)
+		// retries are run one at a time, and the pipeline's receivers only wait for them once depth batches are
+		// queued
+		retries = make(chan func(), idx.pipelineDepth)
+		go func() {
+			for retry := range retries {
+				retry()
+			}
+			close(retried)
+		}()
+	}
+	for {
+		doc, origin, ok := idx.receive()
+		if !ok {
+			break
+		}
+		if doc.Id == "" {
+			if idx.progress != nil {
+				idx.progress.indexed(origin)
+			}
+			continue
 		}
+		if idx.corpus != nil {
+			idx.corpus.Observe(doc)
+		}
+		chunk = append(chunk, doc)
+		if idx.progress != nil {
+			origins = append(origins, origin)
+		}
+		if len(chunk) == N {
+			idx.send(rng, pipe, retries, chunk, origins)
+			chunk, origins = chunk[:0], origins[:0]
+		}
+	}
+	// the last documents read don't fill a chunk
+	if len(chunk) > 0 {
+		idx.send(rng, pipe, retries, chunk, origins)
+	}
+	if pipe != nil {
+		pipe.Close()
+		close(retries)
+		<-retried
 	}
 	idx.wg.Done()
 }
 
+// New creates an indexer for a comma separated list of hosts. If they are a redis cluster, documents are sent
+// to the nodes that own their ids' hash slots
 func New(name, host string, concurrency int, ch chan redisearch.Document,
 	parser DocumentParser, sp SchemaProvider, chunkSize int) *Indexer {
+	// besides a connection per worker, there are connections for redirects and retries of pipelined commands,
+	// for searchable lag probes and for polling server stats
+	cc, err := cluster.New(host, 3*concurrency+maxLagProbes+1)
+	if err != nil {
+		panic(err)
+	}
 	ret := &Indexer{
-		client:      redisearch.NewClient(host, name),
+		name:        name,
+		client:      redisearch.NewClient(cc.Any().Addr, name),
+		cc:          cc,
 		concurrency: concurrency,
 		ch:          ch,
 		parser:      parser,
@@ -128,36 +319,204 @@ func New(name, host string, concurrency int, ch chan redisearch.Document,
 		lastTime:    time.Now(),
 		chunkSize:   chunkSize,
 		cw:          csv.NewWriter(os.Stdout),
+		ready:       make(chan struct{}),
+		noSave:      true,
+
+		reportInterval: DefaultReportInterval,
+		retryPolicy:    DefaultRetryPolicy,
+		hist:           stats.NewHistogram(),
+		intervalHist:   stats.NewHistogram(),
+	}
+	for i := range ret.opStats {
+		ret.opStats[i] = newOpStats()
 	}
 
-	ret.cw.Write([]string{
-		"Time Elapsed",
-		"Documents Indexed",
-		"Documents/Second",
-		"Avg. Latency",
-		"MBs/Second",
-	})
+	ret.cw.Write(append(reportHeader, serverStatsHeader...))
 	ret.cw.Flush()
 	return ret
 }
 
+// SetPipelineDepth sets how many chunks every connection sends without waiting for the replies to the previous
+// ones. With a depth of 1, every chunk is sent after the previous one's replies arrive
+func (idx *Indexer) SetPipelineDepth(depth int) {
+	idx.pipelineDepth = depth
+}
+
+// SetCorpusModel makes the indexer add the documents it reads to m
+func (idx *Indexer) SetCorpusModel(m *CorpusModel) {
+	idx.corpus = m
+}
+
+// SetRateProfile limits the indexing rate to the given profile, instead of indexing as fast as possible
+func (idx *Indexer) SetRateProfile(p RateProfile) {
+	idx.profile = p
+}
+
+// SetPhaseDocs makes Phase split indexing into phases of n documents each
+func (idx *Indexer) SetPhaseDocs(n int) {
+	idx.phaseDocs = uint64(n)
+}
+
+// SetOpMix makes the indexer run a weighted mix of adds, updates and deletes on the documents it reads,
+// instead of only adding them. Updates and deletes target the ids of the documents as read, unless
+// SetIDSampling is set. Documents are saved unless all the operations can work on unsaved documents
+func (idx *Indexer) SetOpMix(mix *OpMix) {
+	idx.mix = mix
+	idx.noSave = !mix.needsSave()
+}
+
+// SetPartialUpdate sets the fields that partial and conditional updates send (all of the document's fields
+// if empty), and the condition of conditional updates
+func (idx *Indexer) SetPartialUpdate(fields []string, condition string) {
+	idx.partialFields = fields
+	idx.condition = condition
+}
+
+// SetIDSampling makes updates and deletes target ids sampled from up to size documents added or replaced
+// so far, instead of the ids of the documents as read
+func (idx *Indexer) SetIDSampling(size int) {
+	idx.ids = newIDSample(size)
+}
+
+// SetStorage sets how documents are written: with FT.ADD, or as documents under a key prefix that the index
+// is created on
+func (idx *Indexer) SetStorage(s Storage, prefix string) {
+	idx.storage = s
+	idx.prefix = prefix
+}
+
+// AddVectorField adds a vector field to the schema of the index. It needs hash or JSON storage
+func (idx *Indexer) AddVectorField(f VectorField) {
+	idx.vectors = append(idx.vectors, f)
+}
+
+// SetLagSampling measures the lag between writing a document and it becoming searchable, for one in every
+// n documents added
+func (idx *Indexer) SetLagSampling(n int) {
+	idx.lag = newLagProbe(idx.cc, idx.name, n)
+}
+
+// Ready returns a channel that is closed once the index has been created and documents start being indexed
+func (idx *Indexer) Ready() <-chan struct{} {
+	return idx.ready
+}
+
+// Phase returns the current indexing phase, for segmenting benchmarks that run concurrently with indexing:
+// "indexing" (or "indexing:<from>-<to>" with SetPhaseDocs) while documents are indexed, and
+// "after_indexing" once all documents have been indexed
+func (idx *Indexer) Phase() string {
+	if atomic.LoadInt32(&idx.done) == 1 {
+		return "after_indexing"
+	}
+	if idx.phaseDocs == 0 {
+		return "indexing"
+	}
+	from := atomic.LoadUint64(&idx.counter) / idx.phaseDocs * idx.phaseDocs
+	return fmt.Sprintf("indexing:%d-%d", from, from+idx.phaseDocs)
+}
+
 func (idx *Indexer) Start() {
-	idx.client.Drop()
+	// a resumed run continues the elapsed time of the previous one
+	idx.start = time.Now().Add(-idx.resumedElapsed)
+	if idx.storage == StorageJSON {
+		idx.numeric = numericFields(idx.sp.Schema())
+	}
+	if idx.mix != nil && !idx.mix.Has(OpAdd) {
+		// update and delete workloads run against an existing index
+		idx.run()
+		return
+	}
 	// sc := redisearch.NewSchema(redisearch.DefaultOptions).
 	// 	AddField(redisearch.NewTextField("body")).
 	// 	AddField(redisearch.NewTextField("author")).
 	// 	AddField(redisearch.NewTextField("sub")).
 	// 	AddField(redisearch.NewNumericField("date"))
-	sc := idx.sp.Schema()
-
-	if err := idx.client.CreateIndex(sc); err != nil {
+	if err := idx.prepareIndex(); err != nil {
 		panic(err)
 	}
+	idx.run()
+}
+
+func (idx *Indexer) dropIndex() {
+	if idx.storage == StorageFtAdd {
+		idx.client.Drop()
+		return
+	}
+	// drop the documents of the previous run along with the index
+	conn := idx.cc.Any().Get()
+	defer conn.Close()
+	conn.Do("FT.DROPINDEX", idx.name, "DD")
+}
+
+func (idx *Indexer) createIndex(sc *redisearch.Schema) error {
+	if idx.storage == StorageFtAdd {
+		return idx.client.CreateIndex(sc)
+	}
+	conn := idx.cc.Any().Get()
+	defer conn.Close()
+	_, err := conn.Do("FT.CREATE", createArgs(idx.name, idx.storage, idx.prefix, sc, idx.vectors)...)
+	return err
+}
+
+func (idx *Indexer) run() {
+	if idx.profile != nil {
+		idx.limiter = newLimiter(idx.profile)
+	}
+	close(idx.ready)
+	// the memory used before indexing is the baseline of the memory used per document
+	idx.pollServer()
+	idx.lastTime = time.Now()
+	stop, reported, checkpointed := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go idx.reportLoop(stop, reported)
+	if idx.progress != nil {
+		go idx.checkpointLoop(stop, checkpointed)
+	} else {
+		close(checkpointed)
+	}
 	for i := 0; i < idx.concurrency; i++ {
 		idx.wg.Add(1)
 		go idx.loop()
 	}
 	idx.wg.Wait()
+	idx.elapsed = time.Since(idx.start)
+	atomic.StoreInt32(&idx.done, 1)
+	close(stop)
+	<-reported
+	<-checkpointed
+	if idx.deadLetters != nil {
+		if err := idx.deadLetters.close(); err != nil {
+			log.Printf("Error closing dead letters: %s", err)
+		}
+	}
+	s := idx.Summary()
+	log.Printf("Indexing finished: %d docs indexed in %.02fs, rate %.02fdocs/sec, %.02fMB/s, "+
+		"latency p50 %.02fms p99 %.02fms max %.02fms, %d dropped (%.02f%%), %d failed attempts %s",
+		s.Docs, s.Duration, s.DocsPerSec, s.MBPerSec, s.Latency.P50, s.Latency.P99, s.Latency.Max,
+		s.Dropped, s.ErrorRate*100, s.Errors, idx.errors.String())
+	if s.Retries > 0 || s.Dropped > 0 {
+		log.Printf("%d writes retried, %d docs dropped", s.Retries, s.Dropped)
+	}
+	if s.Server != nil {
+		log.Printf("Index size %.02fMB: %.02f index bytes/doc, %.02f memory bytes/doc, index/raw data ratio %.02f",
+			s.Server.IndexSize()/(1024*1024), s.IndexBytesPerDoc, s.MemoryBytesPerDoc, s.IndexToRawRatio)
+	}
+	if nodes := idx.cc.NodeStats(); len(nodes) > 1 {
+		for _, n := range nodes {
+			log.Printf("Node %s: %d docs, rate %.02fdocs/sec, p50 latency %.02fms, p99 latency %.02fms, errors: %d",
+				n.Addr, n.Requests, n.RPS, n.Latency.P50, n.Latency.P99, n.Errors)
+		}
+	}
+	if idx.lag != nil {
+		l := idx.lag.stats()
+		log.Printf("Searchable lag of %d docs: p50 %.02fms, p99 %.02fms, max %.02fms, timeouts: %d",
+			l.Probes, l.Lag.P50, l.Lag.P99, l.Lag.Max, l.Timeouts)
+	}
+	if idx.mix != nil {
+		for _, s := range idx.OpStats() {
+			log.Printf("Op %s: %d docs, rate %.02fdocs/sec, p50 latency %.02fms, p99 latency %.02fms, errors: %d, dropped: %d",
+				s.Op, s.Count, s.RPS, s.Latency.P50, s.Latency.P99, s.Errors, s.Dropped)
+		}
+	}
 }
 
 // Returns the number of documents indexed
@@ -165,3 +524,38 @@ func (idx *Indexer) GetNumIndexed() int {
 	idx.wg.Wait()
 	return int(idx.counter)
 }
+
+// Returns the per node indexing stats
+func (idx *Indexer) NodeStats() []cluster.NodeStats {
+	return idx.cc.NodeStats()
+}
+
+// Returns the stats of every operation that was run
+func (idx *Indexer) OpStats() []OpStats {
+	ret := []OpStats{}
+	elapsed := time.Since(idx.start)
+	if atomic.LoadInt32(&idx.done) == 1 {
+		elapsed = idx.elapsed
+	}
+	for op, s := range idx.opStats {
+		if st := s.summary(Op(op), elapsed); st.Count+st.Errors > 0 {
+			ret = append(ret, st)
+		}
+	}
+	return ret
+}
+
+// Returns the searchable lag stats, if lag sampling is set
+func (idx *Indexer) LagStats() *LagStats {
+	if idx.lag == nil {
+		return nil
+	}
+	l := idx.lag.stats()
+	return &l
+}
+
+// Returns the number of documents that failed to index
+func (idx *Indexer) GetNumErrors() int {
+	idx.wg.Wait()
+	return int(atomic.LoadUint64(&idx.dropped))
+}
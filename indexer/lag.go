@@ -0,0 +1,115 @@
+package indexer
+
+import (
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/RedisLabs/rsbench/stats"
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// maxLagProbes is the number of documents whose searchable lag is measured at the same time. Documents
+	// sampled while all probes are busy are skipped
+	maxLagProbes = 16
+	// lagTimeout is how long a probe waits for a document to become searchable
+	lagTimeout = 10 * time.Second
+	// lagPollInterval is the pause between searches for a document that is not searchable yet
+	lagPollInterval = time.Millisecond
+)
+
+// lagProbe measures the lag between writing a document and it becoming searchable, by searching for a sample
+// of the written documents' keys until they are found
+type lagProbe struct {
+	cc    *cluster.Client
+	index string
+	every uint64
+	count uint64
+	slots chan struct{}
+
+	mu       sync.Mutex
+	hist     *stats.Histogram
+	timeouts uint64
+}
+
+// LagStats are the searchable lags of the sampled documents, in milliseconds
+type LagStats struct {
+	Probes   int64         `json:"probes"`
+	Timeouts uint64        `json:"timeouts"`
+	Lag      stats.Summary `json:"lag"`
+}
+
+func newLagProbe(cc *cluster.Client, index string, every int) *lagProbe {
+	return &lagProbe{
+		cc:    cc,
+		index: index,
+		every: uint64(every),
+		slots: make(chan struct{}, maxLagProbes),
+		hist:  stats.NewHistogram(),
+	}
+}
+
+// written is called with the key of every document once its write is acknowledged at time t, and probes
+// one in every p.every of them
+func (p *lagProbe) written(key string, t time.Time) {
+	if atomic.AddUint64(&p.count, 1)%p.every != 0 {
+		return
+	}
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return
+	}
+	go func() {
+		defer func() { <-p.slots }()
+		p.probe(key, t)
+	}()
+}
+
+// probe searches the node that serves key for it until it is found. Searches bypass the node's stats, so that
+// they do not count as indexing requests
+func (p *lagProbe) probe(key string, t time.Time) {
+	n := p.cc.NodeForKey(key)
+	for time.Since(t) < lagTimeout {
+		conn := n.Get()
+		res, err := redis.Values(conn.Do("FT.SEARCH", p.index, "*", "INKEYS", 1, key, "NOCONTENT", "LIMIT", 0, 0))
+		conn.Close()
+		if err != nil {
+			log.Printf("Error probing searchable lag of %s: %s\n", key, err)
+			return
+		}
+		if len(res) == 0 {
+			return
+		}
+		if found, _ := redis.Int(res[0], nil); found > 0 {
+			p.mu.Lock()
+			p.hist.Record(time.Since(t))
+			p.mu.Unlock()
+			return
+		}
+		time.Sleep(lagPollInterval)
+	}
+	p.mu.Lock()
+	p.timeouts++
+	p.mu.Unlock()
+}
+
+func (p *lagProbe) stats() LagStats {
+	// wait for in flight probes
+	for i := 0; i < maxLagProbes; i++ {
+		p.slots <- struct{}{}
+	}
+	for i := 0; i < maxLagProbes; i++ {
+		<-p.slots
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return LagStats{
+		Probes:   p.hist.Count(),
+		Timeouts: p.timeouts,
+		Lag:      p.hist.Summary(),
+	}
+}
@@ -0,0 +1,172 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// IndexMode is what Start does with the index before indexing
+type IndexMode int
+
+const (
+	// IndexDropCreate drops the index and creates it anew
+	IndexDropCreate IndexMode = iota
+	// IndexCreateIfMissing creates the index if it doesn't exist, and adds to it otherwise
+	IndexCreateIfMissing
+	// IndexAppend adds to an existing index, whose fields must match the schema
+	IndexAppend
+	// IndexSkipCreate indexes without touching or checking the index
+	IndexSkipCreate
+)
+
+var indexModeNames = []string{"drop", "create", "append", "skip"}
+
+func (m IndexMode) String() string {
+	return indexModeNames[m]
+}
+
+// ParseIndexMode parses an index mode name
+func ParseIndexMode(s string) (IndexMode, error) {
+	for i, name := range indexModeNames {
+		if name == s {
+			return IndexMode(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid index mode %q, expected one of %s", s, strings.Join(indexModeNames, ","))
+}
+
+// SetIndexMode sets what Start does with the index. In drop mode, an index that holds documents is only dropped
+// if force is set
+func (idx *Indexer) SetIndexMode(m IndexMode, force bool) {
+	idx.indexMode = m
+	idx.forceDrop = force
+}
+
+// isUnknownIndex returns true for the errors of commands on an index that doesn't exist, which vary between
+// server versions
+func isUnknownIndex(err error) bool {
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "unknown index") || strings.Contains(msg, "no such index")
+}
+
+// indexFields returns the types of the fields of an existing index by name, or false if the index doesn't exist
+func (idx *Indexer) indexFields() (map[string]string, bool, error) {
+	conn := idx.cc.Any().Get()
+	defer conn.Close()
+	info, err := redis.Values(conn.Do("FT.INFO", idx.name))
+	if err != nil {
+		if isUnknownIndex(err) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	ret := map[string]string{}
+	for i := 0; i+1 < len(info); i += 2 {
+		// the fields are "fields" up to RediSearch 2.0, and "attributes" since
+		if key, _ := redis.String(info[i], nil); key != "fields" && key != "attributes" {
+			continue
+		}
+		fields, _ := redis.Values(info[i+1], nil)
+		for _, f := range fields {
+			vals, _ := redis.Values(f, nil)
+			strs := make([]string, len(vals))
+			for j, v := range vals {
+				strs[j], _ = redis.String(v, nil)
+			}
+			// old servers list the name first, newer ones an identifier and an attribute name
+			name, typ := "", ""
+			if len(strs) > 0 && strs[0] != "identifier" {
+				name = strs[0]
+			}
+			for j := 0; j+1 < len(strs); j++ {
+				switch strs[j] {
+				case "attribute":
+					name = strs[j+1]
+				case "type":
+					typ = strs[j+1]
+				}
+			}
+			if name != "" {
+				ret[name] = typ
+			}
+		}
+	}
+	return ret, true, nil
+}
+
+// schemaFields returns the types of the fields of the indexer's schema by name
+func (idx *Indexer) schemaFields() map[string]string {
+	ret := map[string]string{}
+	for _, f := range idx.sp.Schema().Fields {
+		ret[f.Name] = fieldArgs(f)[0].(string)
+	}
+	for _, v := range idx.vectors {
+		ret[v.Name] = "VECTOR"
+	}
+	return ret
+}
+
+// schemaDiff describes the differences between the fields of an existing index and the expected ones
+func schemaDiff(existing, expected map[string]string) []string {
+	diffs := []string{}
+	for name, typ := range expected {
+		if got, ok := existing[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("missing field %s", name))
+		} else if got != typ {
+			diffs = append(diffs, fmt.Sprintf("field %s is %s instead of %s", name, got, typ))
+		}
+	}
+	for name := range existing {
+		if _, ok := expected[name]; !ok {
+			diffs = append(diffs, fmt.Sprintf("unexpected field %s", name))
+		}
+	}
+	sort.Strings(diffs)
+	return diffs
+}
+
+// prepareIndex drops, creates or checks the index according to the index mode
+func (idx *Indexer) prepareIndex() error {
+	if idx.indexMode == IndexSkipCreate {
+		return nil
+	}
+	existing, exists, err := idx.indexFields()
+	if err != nil {
+		return fmt.Errorf("could not read index %s: %s", idx.name, err)
+	}
+
+	switch idx.indexMode {
+	case IndexDropCreate:
+		if exists {
+			// a cluster's coordinator counts the documents of all the shards
+			s, err := pollIndex(idx.cc.Any(), idx.name)
+			if err != nil {
+				return fmt.Errorf("could not read index %s: %s", idx.name, err)
+			}
+			if s.NumDocs > 0 && !idx.forceDrop {
+				return fmt.Errorf("index %s holds %d documents, and is only dropped if forced", idx.name, s.NumDocs)
+			}
+			log.Printf("Dropping index %s with %d documents", idx.name, s.NumDocs)
+			idx.dropIndex()
+		}
+	case IndexCreateIfMissing:
+		if exists {
+			log.Printf("Adding to existing index %s", idx.name)
+			return nil
+		}
+	case IndexAppend:
+		if !exists {
+			return fmt.Errorf("index %s doesn't exist", idx.name)
+		}
+		if diffs := schemaDiff(existing, idx.schemaFields()); len(diffs) > 0 {
+			return fmt.Errorf("index %s doesn't match the schema: %s", idx.name, strings.Join(diffs, ", "))
+		}
+		log.Printf("Appending to index %s", idx.name)
+		return nil
+	}
+	return idx.createIndex(idx.sp.Schema())
+}
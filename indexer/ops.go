@@ -0,0 +1,268 @@
+package indexer
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/RedisLabs/rsbench/stats"
+)
+
+// Op is a document operation of an indexing workload
+type Op int
+
+const (
+	// OpAdd adds a new document
+	OpAdd Op = iota
+	// OpReplace replaces a whole document (FT.ADD REPLACE)
+	OpReplace
+	// OpPartial updates some of the fields of a document (FT.ADD REPLACE PARTIAL)
+	OpPartial
+	// OpConditional updates some fields of a document if it matches a condition (FT.ADD REPLACE PARTIAL IF)
+	OpConditional
+	// OpFtDel deletes a document from the index (FT.DEL)
+	OpFtDel
+	// OpDel deletes the document's key (DEL)
+	OpDel
+	numOps
+)
+
+var opNames = [numOps]string{"add", "replace", "partial", "conditional", "ftdel", "del"}
+
+func (o Op) String() string {
+	return opNames[o]
+}
+
+// needsExisting returns true if the operation targets an already indexed document
+func (o Op) needsExisting() bool {
+	return o != OpAdd
+}
+
+// deletes returns true if the operation deletes a document
+func (o Op) deletes() bool {
+	return o == OpFtDel || o == OpDel
+}
+
+// OpMix is a weighted mix of document operations
+type OpMix struct {
+	ops     []Op
+	weights []float64
+}
+
+// ParseOpMix parses a comma separated list of op=weight pairs, e.g. "add=1,replace=2,ftdel=1"
+func ParseOpMix(s string) (*OpMix, error) {
+	mix := &OpMix{}
+	var sum float64
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		w := 1.0
+		if len(kv) == 2 {
+			var err error
+			if w, err = strconv.ParseFloat(kv[1], 64); err != nil || w <= 0 {
+				return nil, fmt.Errorf("invalid op weight %q", part)
+			}
+		}
+		op := Op(-1)
+		for i, name := range opNames {
+			if name == kv[0] {
+				op = Op(i)
+			}
+		}
+		if op < 0 {
+			return nil, fmt.Errorf("invalid op %q, expected one of %s", kv[0], strings.Join(opNames[:], ","))
+		}
+		sum += w
+		mix.ops = append(mix.ops, op)
+		mix.weights = append(mix.weights, sum)
+	}
+	return mix, nil
+}
+
+// Has returns true if the mix has the given operation
+func (m *OpMix) Has(op Op) bool {
+	for _, o := range m.ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *OpMix) pick(rng *rand.Rand) Op {
+	if len(m.ops) == 1 {
+		return m.ops[0]
+	}
+	n := rng.Float64() * m.weights[len(m.weights)-1]
+	return m.ops[sort.SearchFloat64s(m.weights, n)]
+}
+
+// idSample is a bounded random sample of indexed document ids, that update and delete operations pick from
+type idSample struct {
+	mu   sync.Mutex
+	rng  *rand.Rand
+	size int
+	seen int
+	ids  []string
+}
+
+func newIDSample(size int) *idSample {
+	return &idSample{
+		rng:  rand.New(rand.NewSource(time.Now().UnixNano())),
+		size: size,
+		ids:  make([]string, 0, size),
+	}
+}
+
+func (s *idSample) add(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen++
+	if len(s.ids) < s.size {
+		s.ids = append(s.ids, id)
+	} else if n := s.rng.Intn(s.seen); n < s.size {
+		s.ids[n] = id
+	}
+}
+
+// pick returns a random id, removing it from the sample if remove is set
+func (s *idSample) pick(remove bool) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.ids) == 0 {
+		return "", false
+	}
+	n := s.rng.Intn(len(s.ids))
+	id := s.ids[n]
+	if remove {
+		s.ids[n] = s.ids[len(s.ids)-1]
+		s.ids = s.ids[:len(s.ids)-1]
+	}
+	return id, true
+}
+
+// OpStats are the stats of a single operation type. Count is the number of documents written, and Latency is
+// the latency of the batches they were written in. Errors count failed write attempts, Dropped the documents that
+// failed for good, and ErrorRate is the fraction of documents dropped
+type OpStats struct {
+	Op        string        `json:"op"`
+	Count     uint64        `json:"count"`
+	Errors    uint64        `json:"errors"`
+	Dropped   uint64        `json:"dropped"`
+	RPS       float64       `json:"rps"`
+	Latency   stats.Summary `json:"latency"`
+	ErrorRate float64       `json:"error_rate"`
+}
+
+// opStats accumulates the results of a single operation type. It is safe for concurrent use
+type opStats struct {
+	mu      sync.Mutex
+	count   uint64
+	dropped uint64
+	hist    *stats.Histogram
+	errors  stats.ErrorCounts
+}
+
+func newOpStats() *opStats {
+	return &opStats{hist: stats.NewHistogram()}
+}
+
+// record records a batch that wrote n documents in d. The histogram has one sample per batch, like the
+// indexer's, while the count is of documents
+func (s *opStats) record(n int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count += uint64(n)
+	s.hist.Record(d)
+}
+
+// drop records a document that failed for good
+func (s *opStats) drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dropped++
+}
+
+func (s *opStats) summary(op Op, elapsed time.Duration) OpStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	errs := s.errors.Total()
+	return OpStats{
+		Op:        op.String(),
+		Count:     s.count,
+		Errors:    errs,
+		Dropped:   s.dropped,
+		RPS:       float64(s.count) / elapsed.Seconds(),
+		Latency:   s.hist.Summary(),
+		ErrorRate: stats.Rate(s.dropped, s.dropped+s.count),
+	}
+}
+
+// partialDoc returns a copy of doc with only the given fields, or doc itself if fields is empty
+func partialDoc(doc redisearch.Document, fields []string) redisearch.Document {
+	if len(fields) == 0 {
+		return doc
+	}
+	ret := redisearch.NewDocument(doc.Id, doc.Score)
+	for _, f := range fields {
+		if v, ok := doc.Properties[f]; ok {
+			ret = ret.Set(f, v)
+		}
+	}
+	return ret
+}
+
+// needsSave returns true if the mix has operations that read or delete the stored document
+func (m *OpMix) needsSave() bool {
+	return m.Has(OpPartial) || m.Has(OpConditional) || m.Has(OpDel)
+}
+
+// opCmd builds the command of an operation on a document
+func (idx *Indexer) opCmd(op Op, doc redisearch.Document) cluster.Cmd {
+	if idx.storage != StorageFtAdd {
+		return idx.storedOpCmd(op, doc)
+	}
+	opts := redisearch.IndexingOptions{NoSave: idx.noSave}
+	switch op {
+	case OpReplace:
+		opts.Replace = true
+	case OpPartial:
+		opts.Replace = true
+		opts.Partial = true
+		doc = partialDoc(doc, idx.partialFields)
+	case OpConditional:
+		opts.Replace = true
+		opts.Partial = true
+		doc = partialDoc(doc, idx.partialFields)
+		return addCmd(idx.name, opts, idx.condition, doc)
+	case OpFtDel:
+		return cluster.Cmd{Key: doc.Id, Name: "FT.DEL", Args: []interface{}{idx.name, doc.Id}}
+	case OpDel:
+		return cluster.Cmd{Key: doc.Id, Name: "DEL", Args: []interface{}{doc.Id}}
+	}
+	return addCmd(idx.name, opts, "", doc)
+}
+
+// storedOpCmd builds the command of an operation on a document stored as a hash or JSON. Hash replaces
+// overwrite the document's fields, JSON partial updates are merged into the document, and both kinds of deletes
+// delete its key, which also removes it from the index
+func (idx *Indexer) storedOpCmd(op Op, doc redisearch.Document) cluster.Cmd {
+	partial := false
+	switch op {
+	case OpPartial, OpConditional:
+		doc = partialDoc(doc, idx.partialFields)
+		partial = true
+	case OpFtDel, OpDel:
+		key := idx.prefix + doc.Id
+		return cluster.Cmd{Key: key, Name: "DEL", Args: []interface{}{key}}
+	}
+	if idx.storage == StorageJSON {
+		return jsonCmd(idx.prefix, doc, idx.numeric, partial)
+	}
+	return hashCmd(idx.prefix, doc)
+}
@@ -0,0 +1,134 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateProfile returns the target indexing rate, in documents per second, after elapsed time since indexing started
+type RateProfile interface {
+	Rate(elapsed time.Duration) float64
+}
+
+type RateProfileFunc func(elapsed time.Duration) float64
+
+func (f RateProfileFunc) Rate(elapsed time.Duration) float64 {
+	return f(elapsed)
+}
+
+// ConstantRate indexes at a fixed rate
+func ConstantRate(rate float64) RateProfile {
+	return RateProfileFunc(func(time.Duration) float64 {
+		return rate
+	})
+}
+
+// LinearRamp goes linearly from one rate to another over a duration, and then stays at the final rate
+func LinearRamp(from, to float64, over time.Duration) RateProfile {
+	return RateProfileFunc(func(elapsed time.Duration) float64 {
+		if elapsed >= over {
+			return to
+		}
+		return from + (to-from)*elapsed.Seconds()/over.Seconds()
+	})
+}
+
+// StepRate starts at a rate and increases it by step every interval
+func StepRate(start, step float64, every time.Duration) RateProfile {
+	return RateProfileFunc(func(elapsed time.Duration) float64 {
+		return start + step*float64(elapsed/every)
+	})
+}
+
+// SineRate oscillates around a base rate with the given amplitude and period
+func SineRate(base, amplitude float64, period time.Duration) RateProfile {
+	return RateProfileFunc(func(elapsed time.Duration) float64 {
+		return base + amplitude*math.Sin(2*math.Pi*elapsed.Seconds()/period.Seconds())
+	})
+}
+
+// ParseRateProfile parses a rate profile spec, which is one of:
+//
+//	rate                      constant rate
+//	linear:from:to:duration   linear ramp
+//	step:start:step:every     step increase
+//	sine:base:amplitude:period
+func ParseRateProfile(spec string) (RateProfile, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) == 1 {
+		rate, err := strconv.ParseFloat(parts[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate %q: %s", spec, err)
+		}
+		return ConstantRate(rate), nil
+	}
+	if len(parts) != 4 {
+		return nil, fmt.Errorf("invalid rate profile %q", spec)
+	}
+	a, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate profile %q: %s", spec, err)
+	}
+	b, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate profile %q: %s", spec, err)
+	}
+	d, err := time.ParseDuration(parts[3])
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("invalid rate profile %q: bad duration %s", spec, parts[3])
+	}
+	switch parts[0] {
+	case "linear":
+		return LinearRamp(a, b, d), nil
+	case "step":
+		return StepRate(a, b, d), nil
+	case "sine":
+		return SineRate(a, b, d), nil
+	}
+	return nil, fmt.Errorf("invalid rate profile %q: unknown profile %s", spec, parts[0])
+}
+
+// minRate keeps profiles that drop to zero or below from stalling indexing forever
+const minRate = 1
+
+// limiter paces documents to a rate profile. It is shared by all the indexing goroutines
+type limiter struct {
+	mu      sync.Mutex
+	profile RateProfile
+	start   time.Time
+	next    time.Time
+}
+
+func newLimiter(profile RateProfile) *limiter {
+	now := time.Now()
+	return &limiter{
+		profile: profile,
+		start:   now,
+		next:    now,
+	}
+}
+
+// Wait blocks until n more documents may be sent
+func (l *limiter) Wait(n int) {
+	l.mu.Lock()
+	now := time.Now()
+	// don't let idle time accumulate into a burst
+	if l.next.Before(now) {
+		l.next = now
+	}
+	t := l.next
+	rate := math.Max(l.profile.Rate(t.Sub(l.start)), minRate)
+	l.next = t.Add(time.Duration(float64(n) / rate * float64(time.Second)))
+	l.mu.Unlock()
+
+	time.Sleep(time.Until(t))
+}
+
+// Rate returns the current target rate
+func (l *limiter) Rate() float64 {
+	return math.Max(l.profile.Rate(time.Since(l.start)), minRate)
+}
@@ -0,0 +1,297 @@
+package indexer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/RedisLabs/rsbench/stats"
+)
+
+// DefaultReportInterval is the interval of indexing progress reports
+const DefaultReportInterval = 5 * time.Second
+
+// reportHeader names the columns of the progress reports. Batch latencies are in milliseconds
+var reportHeader = []string{
+	"Time Elapsed",
+	"Documents Indexed",
+	"Documents/Second",
+	"Avg. Latency",
+	"p50 Latency",
+	"p99 Latency",
+	"Max Latency",
+	"MBs/Second",
+	"Errors",
+}
+
+// Summary is the final report of an indexing run
+type Summary struct {
+	Docs       uint64            `json:"docs"`
+	Bytes      uint64            `json:"bytes"`
+	Duration   float64           `json:"duration"`
+	DocsPerSec float64           `json:"docs_per_sec"`
+	MBPerSec   float64           `json:"mb_per_sec"`
+	Batches    int64             `json:"batches"`
+	Latency    stats.Summary     `json:"latency"`
+	Errors     uint64            `json:"errors"`
+	ErrorRate  float64           `json:"error_rate"`
+	ErrorTypes map[string]uint64 `json:"error_types"`
+	// Errors count every failed write attempt, Retries the writes that were retried, and Dropped the documents
+	// that failed for good. ErrorRate is the fraction of documents dropped
+	Retries uint64 `json:"retries"`
+	Dropped uint64 `json:"dropped"`
+
+	// Server are the last server stats polled, and the derived sizes per document. MemoryBytesPerDoc is the
+	// growth of the used memory per document added since indexing started, and IndexToRawRatio the size of the
+	// index relative to the documents' data
+	Server            *ServerStats `json:"server,omitempty"`
+	IndexBytesPerDoc  float64      `json:"index_bytes_per_doc,omitempty"`
+	MemoryBytesPerDoc float64      `json:"memory_bytes_per_doc,omitempty"`
+	IndexToRawRatio   float64      `json:"index_to_raw_ratio,omitempty"`
+
+	Ops   []OpStats           `json:"ops,omitempty"`
+	Nodes []cluster.NodeStats `json:"nodes,omitempty"`
+	Lag   *LagStats           `json:"lag,omitempty"`
+}
+
+// SetReportInterval sets the interval of progress reports
+func (idx *Indexer) SetReportInterval(d time.Duration) {
+	idx.reportInterval = d
+}
+
+// recordLatency adds the latency of a batch to the run's and the current interval's histograms
+func (idx *Indexer) recordLatency(d time.Duration) {
+	idx.histMu.Lock()
+	defer idx.histMu.Unlock()
+	idx.hist.Record(d)
+	idx.intervalHist.Record(d)
+}
+
+// reportLoop reports progress every interval until stop is closed, then reports the last interval and
+// closes done
+func (idx *Indexer) reportLoop(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(idx.reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			idx.report()
+		case <-stop:
+			idx.report()
+			return
+		}
+	}
+}
+
+// report writes a CSV row and logs the progress since the previous report
+func (idx *Indexer) report() {
+	x := atomic.LoadUint64(&idx.counter)
+	if x == 0 && idx.errors.Total() == 0 {
+		// nothing was indexed yet
+		return
+	}
+	elapsed := time.Since(idx.start)
+	currentTime := time.Since(idx.lastTime)
+	idx.histMu.Lock()
+	latency := idx.intervalHist.Summary()
+	idx.intervalHist.Reset()
+	idx.histMu.Unlock()
+	dataRate := (float64(atomic.SwapUint64(&idx.lastDataSize, 0)) / currentTime.Seconds()) / (1024 * 1024)
+	rate := float64(x-idx.lastCount) / currentTime.Seconds()
+	errs := idx.errors.Total()
+	dropped := atomic.LoadUint64(&idx.dropped)
+	server := idx.pollServer()
+
+	row := []string{
+		strconv.FormatFloat(elapsed.Seconds(), 'f', 2, 32),
+		strconv.FormatUint(x, 10),
+		strconv.FormatFloat(rate, 'f', 2, 32),
+		strconv.FormatFloat(latency.Avg, 'f', 2, 32),
+		strconv.FormatFloat(latency.P50, 'f', 2, 32),
+		strconv.FormatFloat(latency.P99, 'f', 2, 32),
+		strconv.FormatFloat(latency.Max, 'f', 2, 32),
+		strconv.FormatFloat(dataRate, 'f', 2, 32),
+		strconv.FormatUint(errs, 10),
+	}
+	if server != nil {
+		row = append(row, server.CSV()...)
+	} else {
+		// keep the rows as wide as the header when the server stats can't be polled
+		row = append(row, make([]string, len(serverStatsHeader))...)
+	}
+	idx.cw.Write(row)
+	idx.cw.Flush()
+	log.Printf("Indexed %d docs in %v, rate %.02fdocs/sec, latency p50 %.02fms p99 %.02fms max %.02fms, dataRate: %.02fMB/s, errors: %d %s, dropped: %d (%.02f%%)",
+		x, elapsed, rate, latency.P50, latency.P99, latency.Max, dataRate,
+		errs, idx.errors.String(), dropped, stats.Rate(dropped, dropped+x)*100)
+	if server != nil {
+		log.Printf("Server: %d docs, %d terms, inverted index %.02fMB, used memory %.02fMB, fragmentation %.02f, "+
+			"indexing failures %d, %.02f%% indexed", server.NumDocs, server.NumTerms, server.InvertedSizeMB,
+			float64(server.UsedMemory)/(1024*1024), server.Fragmentation, server.IndexingFailures,
+			server.PercentIndexed*100)
+	}
+	if idx.limiter != nil {
+		log.Printf("Target rate %.02fdocs/sec", idx.limiter.Rate())
+	}
+
+	idx.lastCount = x
+	idx.lastTime = time.Now()
+}
+
+// Summary returns the report of the whole run, or of the run so far if it is not done yet
+func (idx *Indexer) Summary() Summary {
+	elapsed := time.Since(idx.start)
+	if atomic.LoadInt32(&idx.done) == 1 {
+		elapsed = idx.elapsed
+	}
+	docs := atomic.LoadUint64(&idx.counter)
+	bytes := atomic.LoadUint64(&idx.totalDataSize)
+	dropped := atomic.LoadUint64(&idx.dropped)
+	idx.histMu.Lock()
+	latency, batches := idx.hist.Summary(), idx.hist.Count()
+	idx.histMu.Unlock()
+	s := Summary{
+		Docs:       docs,
+		Bytes:      bytes,
+		Duration:   elapsed.Seconds(),
+		DocsPerSec: float64(docs) / elapsed.Seconds(),
+		MBPerSec:   float64(bytes) / elapsed.Seconds() / (1024 * 1024),
+		Batches:    batches,
+		Latency:    latency,
+		Errors:     idx.errors.Total(),
+		ErrorRate:  stats.Rate(dropped, dropped+docs),
+		ErrorTypes: idx.errors.Map(),
+		Retries:    atomic.LoadUint64(&idx.retries),
+		Dropped:    dropped,
+		Lag:        idx.LagStats(),
+	}
+	idx.serverMu.Lock()
+	if idx.server != nil {
+		server := *idx.server
+		s.Server = &server
+		if server.NumDocs > 0 {
+			s.IndexBytesPerDoc = server.IndexSize() / float64(server.NumDocs)
+		}
+		if added := server.NumDocs - idx.baseline.NumDocs; added > 0 {
+			s.MemoryBytesPerDoc = float64(server.UsedMemory-idx.baseline.UsedMemory) / float64(added)
+		}
+		if bytes > 0 {
+			s.IndexToRawRatio = server.IndexSize() / float64(bytes)
+		}
+	}
+	idx.serverMu.Unlock()
+	if idx.mix != nil {
+		s.Ops = idx.OpStats()
+	}
+	if nodes := idx.cc.NodeStats(); len(nodes) > 1 {
+		s.Nodes = nodes
+	}
+	return s
+}
+
+// summaryCSVHeader names the columns of DumpCSV
+var summaryCSVHeader = append([]string{
+	"Name",
+	"Documents",
+	"Bytes",
+	"Duration",
+	"Documents/Second",
+	"MBs/Second",
+	"Batches",
+	"Errors",
+	"Error Rate",
+	"Retries",
+	"Dropped",
+}, stats.SummaryCSVHeader...)
+
+// derivedCSVHeader names the columns of the derived server stats, which follow the server stats in DumpCSV
+var derivedCSVHeader = []string{"Index Bytes/Doc", "Memory Bytes/Doc", "Index/Raw Ratio"}
+
+// DumpCSV writes a header and a row for the whole run named "*", followed by a row per operation named
+// "op:<name>" if an operation mix is set, and a row per node named "node:<addr>" for clusters. Server stats
+// are only in the "*" row
+func (idx *Indexer) DumpCSV(out io.Writer) error {
+	s := idx.Summary()
+	cw := csv.NewWriter(out)
+	header := summaryCSVHeader
+	if s.Server != nil {
+		header = append(append(append([]string{}, header...), serverStatsHeader...), derivedCSVHeader...)
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	row := append([]string{
+		"*",
+		strconv.FormatUint(s.Docs, 10),
+		strconv.FormatUint(s.Bytes, 10),
+		strconv.FormatFloat(s.Duration, 'f', 2, 64),
+		strconv.FormatFloat(s.DocsPerSec, 'f', 2, 64),
+		strconv.FormatFloat(s.MBPerSec, 'f', 2, 64),
+		strconv.FormatInt(s.Batches, 10),
+		strconv.FormatUint(s.Errors, 10),
+		strconv.FormatFloat(s.ErrorRate, 'f', 4, 64),
+		strconv.FormatUint(s.Retries, 10),
+		strconv.FormatUint(s.Dropped, 10),
+	}, s.Latency.CSV()...)
+	if s.Server != nil {
+		row = append(row, s.Server.CSV()...)
+		row = append(row,
+			strconv.FormatFloat(s.IndexBytesPerDoc, 'f', 2, 64),
+			strconv.FormatFloat(s.MemoryBytesPerDoc, 'f', 2, 64),
+			strconv.FormatFloat(s.IndexToRawRatio, 'f', 4, 64),
+		)
+	}
+	if err := cw.Write(row); err != nil {
+		return err
+	}
+	// operations have no byte, batch or retry counts of their own, and nodes no dropped counts either
+	for _, o := range s.Ops {
+		if err := cw.Write(append([]string{
+			"op:" + o.Op,
+			strconv.FormatUint(o.Count, 10),
+			"",
+			strconv.FormatFloat(s.Duration, 'f', 2, 64),
+			strconv.FormatFloat(o.RPS, 'f', 2, 64),
+			"",
+			"",
+			strconv.FormatUint(o.Errors, 10),
+			strconv.FormatFloat(o.ErrorRate, 'f', 4, 64),
+			"",
+			strconv.FormatUint(o.Dropped, 10),
+		}, o.Latency.CSV()...)); err != nil {
+			return err
+		}
+	}
+	for _, n := range s.Nodes {
+		if err := cw.Write(append([]string{
+			"node:" + n.Addr,
+			strconv.FormatUint(n.Requests, 10),
+			"",
+			strconv.FormatFloat(s.Duration, 'f', 2, 64),
+			strconv.FormatFloat(n.RPS, 'f', 2, 64),
+			"",
+			"",
+			strconv.FormatUint(n.Errors, 10),
+			strconv.FormatFloat(n.ErrorRate, 'f', 4, 64),
+			"",
+			"",
+		}, n.Latency.CSV()...)); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// DumpJson writes the summary as JSON
+func (idx *Indexer) DumpJson(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "\t")
+	return enc.Encode(idx.Summary())
+}
@@ -0,0 +1,190 @@
+package indexer
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/gomodule/redigo/redis"
+)
+
+// RetryPolicy is how writes that fail with a retryable error are retried: up to MaxRetries times, after an
+// exponential backoff starting at Backoff and capped at MaxBackoff, with jitter
+type RetryPolicy struct {
+	MaxRetries int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRetryPolicy retries 3 times, backing off up to 100ms, 200ms and 400ms
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries: 3,
+	Backoff:    100 * time.Millisecond,
+	MaxBackoff: 5 * time.Second,
+}
+
+// backoff returns the pause before a retry, which is between half and all of the exponential backoff so that
+// writers that failed together don't retry together
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	if p.Backoff <= 0 {
+		return 0
+	}
+	d := p.Backoff << uint(retry)
+	// the shift overflows to negative durations after many retries
+	if d > p.MaxBackoff || d <= 0 {
+		d = p.MaxBackoff
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// retryablePrefixes are the prefixes of the redis errors of a server that is temporarily unable to serve writes
+var retryablePrefixes = []string{"LOADING", "BUSY", "TRYAGAIN", "CLUSTERDOWN", "MASTERDOWN"}
+
+// IsRetryable returns true for the errors of writes that may succeed if retried: network errors, and servers
+// that are loading, busy or reconfiguring. Other errors, e.g. duplicate ids or bad field values, are permanent.
+// Network errors are ambiguous, since the write may have been applied before the connection failed. Writing
+// again is harmless for every operation but adds, which fail on the id the lost write added
+func IsRetryable(err error) bool {
+	if re, ok := err.(redis.Error); ok {
+		for _, p := range retryablePrefixes {
+			if strings.HasPrefix(string(re), p) {
+				return true
+			}
+		}
+		return false
+	}
+	if _, ok := err.(net.Error); ok || err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range []string{"connection reset", "broken pipe", "connection refused", "use of closed network connection"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRetryPolicy sets how failed writes are retried
+func (idx *Indexer) SetRetryPolicy(p RetryPolicy) {
+	idx.retryPolicy = p
+}
+
+// needsRetry returns true if any of errs is retried
+func (idx *Indexer) needsRetry(errs []error) bool {
+	if idx.retryPolicy.MaxRetries == 0 {
+		return false
+	}
+	for _, err := range errs {
+		if err != nil && IsRetryable(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// alreadyAdded returns true if an add failed because its document exists, which for a retried add means that an
+// earlier attempt added it before its connection failed
+func alreadyAdded(op Op, err error) bool {
+	re, ok := err.(redis.Error)
+	return ok && op == OpAdd && strings.Contains(string(re), "Document already exists")
+}
+
+// retryFailed retries the commands that failed with a retryable error, replacing their errors with those of the
+// last attempt, and returns the number of times every command was sent. Retried attempts count as errors, and
+// retried adds of documents that exist succeeded. It sleeps for the backoffs, so it must not run on a pipeline's
+// receiver
+func (idx *Indexer) retryFailed(op Op, cmds []cluster.Cmd, errs []error) []int {
+	attempts := make([]int, len(errs))
+	for i := range attempts {
+		attempts[i] = 1
+	}
+	for retry := 0; ; retry++ {
+		failed := []int{}
+		for i, err := range errs {
+			if err != nil && IsRetryable(err) {
+				failed = append(failed, i)
+			}
+		}
+		if len(failed) == 0 || retry == idx.retryPolicy.MaxRetries {
+			return attempts
+		}
+		for _, i := range failed {
+			idx.errors.Add(errs[i], 1)
+			idx.opStats[op].errors.Add(errs[i], 1)
+		}
+		atomic.AddUint64(&idx.retries, uint64(len(failed)))
+		time.Sleep(idx.retryPolicy.backoff(retry))
+		retryCmds := make([]cluster.Cmd, len(failed))
+		for j, i := range failed {
+			retryCmds[j] = cmds[i]
+		}
+		retryErrs := idx.cc.Pipeline(retryCmds)
+		for j, i := range failed {
+			errs[i] = retryErrs[j]
+			if alreadyAdded(op, errs[i]) {
+				errs[i] = nil
+			}
+			attempts[i]++
+		}
+	}
+}
+
+// deadLetter is a line of the dead letter file: a document that could not be written, and why
+type deadLetter struct {
+	ID       string                 `json:"id"`
+	Op       string                 `json:"op"`
+	Error    string                 `json:"error"`
+	Class    string                 `json:"class"`
+	Attempts int                    `json:"attempts"`
+	Time     time.Time              `json:"time"`
+	Score    float32                `json:"score"`
+	Fields   map[string]interface{} `json:"fields"`
+}
+
+// deadLetters writes the documents that failed for good to a JSON lines file. It is safe for concurrent use
+type deadLetters struct {
+	mu  sync.Mutex
+	fp  *os.File
+	enc *json.Encoder
+}
+
+// SetDeadLetters makes the indexer append the documents that failed for good to a JSON lines file, with their
+// error
+func (idx *Indexer) SetDeadLetters(path string) error {
+	fp, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	idx.deadLetters = &deadLetters{fp: fp, enc: json.NewEncoder(fp)}
+	return nil
+}
+
+func (d *deadLetters) write(op Op, doc redisearch.Document, err error, class string, attempts int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.enc.Encode(deadLetter{
+		ID:       doc.Id,
+		Op:       op.String(),
+		Error:    err.Error(),
+		Class:    class,
+		Attempts: attempts,
+		Time:     time.Now(),
+		Score:    doc.Score,
+		Fields:   doc.Properties,
+	})
+}
+
+func (d *deadLetters) close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.fp.Close()
+}
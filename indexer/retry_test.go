@@ -0,0 +1,108 @@
+package indexer
+
+import (
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{redis.Error("LOADING Redis is loading the dataset in memory"), true},
+		{redis.Error("BUSY Redis is busy running a script"), true},
+		{redis.Error("TRYAGAIN Multiple keys request during rehashing of slot"), true},
+		{redis.Error("CLUSTERDOWN The cluster is down"), true},
+		{redis.Error("MASTERDOWN Link with MASTER is down"), true},
+		{redis.Error("Document already exists"), false},
+		{redis.Error("ERR unknown index name"), false},
+		// redis errors are classified by their prefix only
+		{redis.Error("ERR connection reset by peer"), false},
+		{io.EOF, true},
+		{io.ErrUnexpectedEOF, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{errors.New("write tcp 127.0.0.1:6379: broken pipe"), true},
+		{errors.New("read: connection reset by peer"), true},
+		{errors.New("use of closed network connection"), true},
+		{errors.New("invalid argument"), false},
+	}
+	for _, tc := range tests {
+		if got := IsRetryable(tc.err); got != tc.want {
+			t.Errorf("IsRetryable(%q) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestAlreadyAdded(t *testing.T) {
+	tests := []struct {
+		op   Op
+		err  error
+		want bool
+	}{
+		{OpAdd, redis.Error("Document already exists"), true},
+		{OpReplace, redis.Error("Document already exists"), false},
+		{OpAdd, redis.Error("ERR unknown index name"), false},
+		{OpAdd, errors.New("Document already exists"), false},
+		{OpAdd, nil, false},
+	}
+	for _, tc := range tests {
+		if got := alreadyAdded(tc.op, tc.err); got != tc.want {
+			t.Errorf("alreadyAdded(%s, %v) = %v, want %v", tc.op, tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 100, Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{0, 50 * time.Millisecond, 100 * time.Millisecond},
+		{1, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond, 800 * time.Millisecond},
+		{4, 500 * time.Millisecond, time.Second},
+		{30, 500 * time.Millisecond, time.Second},
+		// the shift overflows
+		{62, 500 * time.Millisecond, time.Second},
+		{100, 500 * time.Millisecond, time.Second},
+	}
+	for _, tc := range tests {
+		for i := 0; i < 100; i++ {
+			if d := p.backoff(tc.retry); d < tc.min || d > tc.max {
+				t.Fatalf("backoff(%d) = %v, want between %v and %v", tc.retry, d, tc.min, tc.max)
+			}
+		}
+	}
+
+	if d := (RetryPolicy{MaxRetries: 3, MaxBackoff: time.Second}).backoff(2); d != 0 {
+		t.Errorf("backoff without a base = %v, want 0", d)
+	}
+}
+
+func TestNeedsRetry(t *testing.T) {
+	retryable, permanent := redis.Error("LOADING Redis is loading"), redis.Error("ERR bad value")
+	tests := []struct {
+		retries int
+		errs    []error
+		want    bool
+	}{
+		{3, []error{nil, nil}, false},
+		{3, []error{nil, permanent}, false},
+		{3, []error{permanent, retryable}, true},
+		{0, []error{retryable}, false},
+	}
+	for _, tc := range tests {
+		idx := &Indexer{retryPolicy: RetryPolicy{MaxRetries: tc.retries}}
+		if got := idx.needsRetry(tc.errs); got != tc.want {
+			t.Errorf("needsRetry(%v) with %d retries = %v, want %v", tc.errs, tc.retries, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Sampling selects the documents a reader sends, in the order they are read: the first Skip documents are
+// skipped, then one in every Every documents, or a random Fraction of them, are kept, up to Max documents.
+// Reading the same files in the same order always selects the same documents
+type Sampling struct {
+	Skip     int
+	Max      int
+	Every    int
+	Fraction float64
+	// Seed is the random seed of Fraction
+	Seed int64
+	// FileSeed shuffles the order a FolderReader reads its files in, which is by path otherwise
+	FileSeed int64
+}
+
+// SamplingParser is a DocumentParser that can sample the documents it reads
+type SamplingParser interface {
+	DocumentParser
+	SetSampling(Sampling)
+}
+
+// Validate checks that the sampling options are in range
+func (s Sampling) Validate() error {
+	if s.Skip < 0 || s.Max < 0 || s.Every < 0 {
+		return fmt.Errorf("sampling skip, max and every can't be negative")
+	}
+	if s.Fraction < 0 || s.Fraction > 1 {
+		return fmt.Errorf("sampling fraction must be between 0 and 1, got %v", s.Fraction)
+	}
+	return nil
+}
+
+// Enabled returns whether any of the sampling options is set
+func (s Sampling) Enabled() bool {
+	return s != Sampling{}
+}
+
+type sampler struct {
+	s    Sampling
+	rng  *rand.Rand
+	read int
+	kept int
+}
+
+func newSampler(s Sampling) *sampler {
+	return &sampler{s: s, rng: rand.New(rand.NewSource(s.Seed))}
+}
+
+// keep returns whether the next document read is kept, and whether documents read after it can be
+func (sp *sampler) keep() (keep, more bool) {
+	sp.read++
+	n := sp.read - sp.s.Skip
+	if n <= 0 {
+		return false, true
+	}
+	if sp.s.Every > 1 && (n-1)%sp.s.Every != 0 {
+		return false, true
+	}
+	if sp.s.Fraction > 0 && sp.rng.Float64() >= sp.s.Fraction {
+		return false, true
+	}
+	sp.kept++
+	return true, sp.s.Max == 0 || sp.kept < sp.s.Max
+}
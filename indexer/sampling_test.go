@@ -0,0 +1,67 @@
+package indexer
+
+import (
+	"reflect"
+	"testing"
+)
+
+// sample returns the numbers, from 1, of the documents a sampler keeps out of n, and how many were read when it
+// stopped
+func sample(s Sampling, n int) ([]int, int) {
+	sp := newSampler(s)
+	kept := []int{}
+	for i := 1; i <= n; i++ {
+		keep, more := sp.keep()
+		if keep {
+			kept = append(kept, i)
+		}
+		if !more {
+			return kept, i
+		}
+	}
+	return kept, n
+}
+
+func TestSamplerKeep(t *testing.T) {
+	tests := []struct {
+		name     string
+		s        Sampling
+		wantKept []int
+		wantRead int
+	}{
+		{"all", Sampling{}, []int{1, 2, 3, 4, 5}, 5},
+		{"skip", Sampling{Skip: 3}, []int{4, 5}, 5},
+		{"skip all", Sampling{Skip: 10}, []int{}, 5},
+		{"max", Sampling{Max: 2}, []int{1, 2}, 2},
+		{"every", Sampling{Every: 2}, []int{1, 3, 5}, 5},
+		{"every one", Sampling{Every: 1}, []int{1, 2, 3, 4, 5}, 5},
+		{"skip then every", Sampling{Skip: 1, Every: 2}, []int{2, 4}, 5},
+		{"every up to max", Sampling{Every: 2, Max: 2}, []int{1, 3}, 3},
+		{"skip, every and max", Sampling{Skip: 2, Every: 3, Max: 1}, []int{3}, 3},
+		{"max not reached", Sampling{Every: 2, Max: 10}, []int{1, 3, 5}, 5},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			kept, read := sample(tc.s, 5)
+			if !reflect.DeepEqual(kept, tc.wantKept) || read != tc.wantRead {
+				t.Errorf("kept %v after reading %d, want %v after %d", kept, read, tc.wantKept, tc.wantRead)
+			}
+		})
+	}
+}
+
+func TestSamplerFraction(t *testing.T) {
+	s := Sampling{Fraction: 0.25, Seed: 42}
+	kept, _ := sample(s, 10000)
+	if len(kept) < 2000 || len(kept) > 3000 {
+		t.Errorf("kept %d of 10000 documents with fraction 0.25", len(kept))
+	}
+	// the same seed selects the same documents
+	if again, _ := sample(s, 10000); !reflect.DeepEqual(kept, again) {
+		t.Error("sampling with the same seed kept different documents")
+	}
+	s.Max = 10
+	if kept, read := sample(s, 10000); len(kept) != 10 || read != kept[9] {
+		t.Errorf("kept %d documents, stopping after %d, with max 10", len(kept), read)
+	}
+}
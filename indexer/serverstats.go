@@ -0,0 +1,177 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/gomodule/redigo/redis"
+)
+
+// ServerStats are the index stats of FT.INFO and the memory stats of INFO memory. In a cluster, the index stats
+// are the coordinator's cluster wide ones, the used memory is summed over the masters and the fragmentation is
+// the highest of them
+type ServerStats struct {
+	NumDocs              int64   `json:"num_docs"`
+	NumTerms             int64   `json:"num_terms"`
+	InvertedSizeMB       float64 `json:"inverted_sz_mb"`
+	OffsetVectorsSizeMB  float64 `json:"offset_vectors_sz_mb"`
+	DocTableSizeMB       float64 `json:"doc_table_size_mb"`
+	SortableValuesSizeMB float64 `json:"sortable_values_size_mb"`
+	UsedMemory           int64   `json:"used_memory"`
+	Fragmentation        float64 `json:"mem_fragmentation_ratio"`
+	IndexingFailures     int64   `json:"hash_indexing_failures"`
+	PercentIndexed       float64 `json:"percent_indexed"`
+}
+
+// IndexSize returns the size of the index structures in bytes
+func (s ServerStats) IndexSize() float64 {
+	return (s.InvertedSizeMB + s.OffsetVectorsSizeMB + s.DocTableSizeMB + s.SortableValuesSizeMB) * 1024 * 1024
+}
+
+// serverStatsHeader names the columns of ServerStats.CSV
+var serverStatsHeader = []string{
+	"Server Docs",
+	"Terms",
+	"Inverted Index MB",
+	"Offset Vectors MB",
+	"Doc Table MB",
+	"Sortable Values MB",
+	"Used Memory MB",
+	"Fragmentation",
+	"Indexing Failures",
+	"Percent Indexed",
+}
+
+// CSV returns the stats as CSV values, in the order of serverStatsHeader
+func (s ServerStats) CSV() []string {
+	return []string{
+		strconv.FormatInt(s.NumDocs, 10),
+		strconv.FormatInt(s.NumTerms, 10),
+		strconv.FormatFloat(s.InvertedSizeMB, 'f', 2, 64),
+		strconv.FormatFloat(s.OffsetVectorsSizeMB, 'f', 2, 64),
+		strconv.FormatFloat(s.DocTableSizeMB, 'f', 2, 64),
+		strconv.FormatFloat(s.SortableValuesSizeMB, 'f', 2, 64),
+		strconv.FormatFloat(float64(s.UsedMemory)/(1024*1024), 'f', 2, 64),
+		strconv.FormatFloat(s.Fragmentation, 'f', 2, 64),
+		strconv.FormatInt(s.IndexingFailures, 10),
+		strconv.FormatFloat(s.PercentIndexed*100, 'f', 2, 64),
+	}
+}
+
+// infoFloat parses an FT.INFO value, which is an integer or a bulk string depending on the field and the
+// server version
+func infoFloat(v interface{}) float64 {
+	switch val := v.(type) {
+	case int64:
+		return float64(val)
+	case []byte:
+		f, _ := strconv.ParseFloat(string(val), 64)
+		return f
+	case string:
+		f, _ := strconv.ParseFloat(val, 64)
+		return f
+	}
+	return 0
+}
+
+// pollIndex reads the FT.INFO stats of an index from a single node, bypassing its request stats
+func pollIndex(n *cluster.Node, index string) (ServerStats, error) {
+	conn := n.Get()
+	defer conn.Close()
+	info, err := redis.Values(conn.Do("FT.INFO", index))
+	if err != nil {
+		return ServerStats{}, err
+	}
+	// servers that don't report the percent indexed index synchronously
+	s := ServerStats{PercentIndexed: 1}
+	for i := 0; i+1 < len(info); i += 2 {
+		key, _ := redis.String(info[i], nil)
+		v := info[i+1]
+		switch key {
+		case "num_docs":
+			s.NumDocs = int64(infoFloat(v))
+		case "num_terms":
+			s.NumTerms = int64(infoFloat(v))
+		case "inverted_sz_mb":
+			s.InvertedSizeMB = infoFloat(v)
+		case "offset_vectors_sz_mb":
+			s.OffsetVectorsSizeMB = infoFloat(v)
+		case "doc_table_size_mb":
+			s.DocTableSizeMB = infoFloat(v)
+		case "sortable_values_size_mb":
+			s.SortableValuesSizeMB = infoFloat(v)
+		case "hash_indexing_failures":
+			s.IndexingFailures = int64(infoFloat(v))
+		case "percent_indexed":
+			s.PercentIndexed = infoFloat(v)
+		}
+	}
+	return s, nil
+}
+
+// pollMemory reads the used memory and fragmentation ratio of INFO memory from a single node
+func pollMemory(n *cluster.Node) (used int64, fragmentation float64, err error) {
+	conn := n.Get()
+	defer conn.Close()
+	mem, err := redis.String(conn.Do("INFO", "memory"))
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(mem, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "used_memory":
+			used, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "mem_fragmentation_ratio":
+			fragmentation, _ = strconv.ParseFloat(kv[1], 64)
+		}
+	}
+	return used, fragmentation, nil
+}
+
+// pollServerStats reads the index stats from any node, since a cluster's coordinator already totals them, and
+// the memory stats of all the nodes
+func pollServerStats(cc *cluster.Client, index string) (ServerStats, error) {
+	n := cc.Any()
+	s, err := pollIndex(n, index)
+	if err != nil {
+		return ServerStats{}, fmt.Errorf("%s: %s", n.Addr, err)
+	}
+	for _, n := range cc.Nodes() {
+		used, fragmentation, err := pollMemory(n)
+		if err != nil {
+			return ServerStats{}, fmt.Errorf("%s: %s", n.Addr, err)
+		}
+		s.UsedMemory += used
+		if fragmentation > s.Fragmentation {
+			s.Fragmentation = fragmentation
+		}
+	}
+	return s, nil
+}
+
+// pollServer polls the server stats, keeping the first ones polled as the baseline of memory growth. Only the
+// first error is logged, e.g. for servers that don't allow INFO
+func (idx *Indexer) pollServer() *ServerStats {
+	s, err := pollServerStats(idx.cc, idx.name)
+	if err != nil {
+		if !idx.serverErrLogged {
+			log.Printf("Error polling server stats: %s", err)
+			idx.serverErrLogged = true
+		}
+		return nil
+	}
+	idx.serverMu.Lock()
+	defer idx.serverMu.Unlock()
+	if idx.baseline == nil {
+		idx.baseline = &s
+	}
+	idx.server = &s
+	return &s
+}
@@ -11,12 +11,13 @@ import (
 )
 
 type SingleFileReader struct {
-	name   string
-	opener DocumentReaderOpener
-	stopch chan bool
+	name     string
+	opener   DocumentReaderOpener
+	stopch   chan bool
+	sampling Sampling
 }
 
-func NewSingleFileReader(name string, opener DocumentReaderOpener) DocumentParser {
+func NewSingleFileReader(name string, opener DocumentReaderOpener) *SingleFileReader {
 	return &SingleFileReader{
 		name:   name,
 		opener: opener,
@@ -37,8 +38,16 @@ func (r *SingleFileReader) Start(ch chan<- redisearch.Document) error {
 	go func() {
 		var err error
 		var doc redisearch.Document
+		sp := newSampler(r.sampling)
 		for err == nil {
 			if doc, err = dr.Read(); err == nil {
+				keep, more := sp.keep()
+				if !more {
+					err = io.EOF
+				}
+				if !keep {
+					continue
+				}
 				select {
 				// stop if we're signaled by stopch
 				case <-r.stopch:
@@ -50,11 +59,21 @@ func (r *SingleFileReader) Start(ch chan<- redisearch.Document) error {
 			}
 		}
 		log.Println("Single file reader exiting, error:", err)
+		if r.sampling.Enabled() {
+			log.Printf("Sampled %d of %d documents read", sp.kept, sp.read)
+		}
+		fp.Close()
+		close(ch)
 	}()
 
 	return nil
 }
 
+// SetSampling makes the reader send only the sampled documents
+func (r *SingleFileReader) SetSampling(s Sampling) {
+	r.sampling = s
+}
+
 func (r *SingleFileReader) Stop() {
 	r.stopch <- true
 	close(r.stopch)
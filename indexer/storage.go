@@ -0,0 +1,184 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
+)
+
+// Storage is how documents are written and indexed
+type Storage int
+
+const (
+	// StorageFtAdd adds documents with FT.ADD
+	StorageFtAdd Storage = iota
+	// StorageHash writes documents as hashes with HSET, indexed by an FT.CREATE ON HASH index
+	StorageHash
+	// StorageJSON writes documents as JSON with JSON.SET, indexed by an FT.CREATE ON JSON index
+	StorageJSON
+)
+
+var storageNames = []string{"ftadd", "hash", "json"}
+
+func (s Storage) String() string {
+	return storageNames[s]
+}
+
+// ParseStorage parses a storage name
+func ParseStorage(s string) (Storage, error) {
+	for i, name := range storageNames {
+		if name == s {
+			return Storage(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid storage %q, expected one of %s", s, strings.Join(storageNames, ","))
+}
+
+const (
+	// the hash fields RediSearch reads document scores and payloads from by default
+	scoreField   = "__score"
+	payloadField = "__payload"
+)
+
+// fieldArgs returns the FT.CREATE SCHEMA arguments of a single field, after its name
+func fieldArgs(f redisearch.Field) []interface{} {
+	var args []interface{}
+	sortable, noIndex := f.Sortable, false
+	switch f.Type {
+	case redisearch.TextField:
+		args = append(args, "TEXT")
+		if opts, ok := f.Options.(redisearch.TextFieldOptions); ok {
+			if opts.Weight != 0 && opts.Weight != 1 {
+				args = append(args, "WEIGHT", strconv.FormatFloat(float64(opts.Weight), 'f', -1, 32))
+			}
+			if opts.NoStem {
+				args = append(args, "NOSTEM")
+			}
+			sortable, noIndex = sortable || opts.Sortable, opts.NoIndex
+		}
+	case redisearch.NumericField:
+		args = append(args, "NUMERIC")
+		if opts, ok := f.Options.(redisearch.NumericFieldOptions); ok {
+			sortable, noIndex = sortable || opts.Sortable, opts.NoIndex
+		}
+	case redisearch.TagField:
+		args = append(args, "TAG")
+		if opts, ok := f.Options.(redisearch.TagFieldOptions); ok {
+			if opts.Separator != 0 {
+				args = append(args, "SEPARATOR", string(opts.Separator))
+			}
+			sortable, noIndex = sortable || opts.Sortable, opts.NoIndex
+		}
+	case redisearch.GeoField:
+		args = append(args, "GEO")
+	}
+	if sortable {
+		args = append(args, "SORTABLE")
+	}
+	if noIndex {
+		args = append(args, "NOINDEX")
+	}
+	return args
+}
+
+// createArgs returns the FT.CREATE arguments of an index of documents stored as s under a key prefix, with
+// sc's options and fields followed by the vector fields. The fields of JSON documents are the top level
+// attributes with their names
+func createArgs(index string, s Storage, prefix string, sc *redisearch.Schema, vectors []VectorField) []interface{} {
+	on := "HASH"
+	if s == StorageJSON {
+		on = "JSON"
+	}
+	args := []interface{}{index, "ON", on, "PREFIX", 1, prefix}
+	if sc.Options.NoOffsetVectors {
+		args = append(args, "NOOFFSETS")
+	}
+	if sc.Options.NoFieldFlags {
+		args = append(args, "NOFIELDS")
+	}
+	if sc.Options.NoFrequencies {
+		args = append(args, "NOFREQS")
+	}
+	if sc.Options.Stopwords != nil {
+		args = append(args, "STOPWORDS", len(sc.Options.Stopwords))
+		for _, w := range sc.Options.Stopwords {
+			args = append(args, w)
+		}
+	}
+	args = append(args, "SCHEMA")
+	for _, f := range sc.Fields {
+		if s == StorageJSON {
+			args = append(args, "$."+f.Name, "AS")
+		}
+		args = append(args, f.Name)
+		args = append(args, fieldArgs(f)...)
+	}
+	for _, f := range vectors {
+		if s == StorageJSON {
+			args = append(args, "$."+f.Name, "AS")
+		}
+		args = append(args, f.Name)
+		args = append(args, f.args()...)
+	}
+	return args
+}
+
+// hashCmd builds the HSET command that writes a document under a key prefix. The score and payload are
+// written to the fields RediSearch reads them from
+func hashCmd(prefix string, doc redisearch.Document) cluster.Cmd {
+	key := prefix + doc.Id
+	args := make([]interface{}, 0, 1+2*len(doc.Properties)+4)
+	args = append(args, key)
+	for k, v := range doc.Properties {
+		args = append(args, k, fieldValue(v))
+	}
+	if doc.Score != 1 {
+		args = append(args, scoreField, doc.Score)
+	}
+	if doc.Payload != nil {
+		args = append(args, payloadField, doc.Payload)
+	}
+	return cluster.Cmd{Key: key, Name: "HSET", Args: args}
+}
+
+// numericFields returns the names of sc's numeric fields
+func numericFields(sc *redisearch.Schema) map[string]bool {
+	ret := map[string]bool{}
+	for _, f := range sc.Fields {
+		if f.Type == redisearch.NumericField {
+			ret[f.Name] = true
+		}
+	}
+	return ret
+}
+
+// jsonDoc encodes a document's fields as a JSON object. Numeric fields are converted to JSON numbers, since
+// strings are not indexed as numbers. Values that do not parse are kept as strings, and fail to index
+func jsonDoc(props map[string]interface{}, numeric map[string]bool) []byte {
+	obj := make(map[string]interface{}, len(props))
+	for k, v := range props {
+		if str, ok := v.(string); ok && numeric[k] {
+			if f, err := strconv.ParseFloat(str, 64); err == nil {
+				v = f
+			}
+		}
+		obj[k] = v
+	}
+	ret, _ := json.Marshal(obj)
+	return ret
+}
+
+// jsonCmd builds the command that writes a document as JSON under a key prefix: JSON.SET for a whole document,
+// or JSON.MERGE for a partial update. Scores and payloads are not stored
+func jsonCmd(prefix string, doc redisearch.Document, numeric map[string]bool, partial bool) cluster.Cmd {
+	key := prefix + doc.Id
+	cmd := "JSON.SET"
+	if partial {
+		cmd = "JSON.MERGE"
+	}
+	return cluster.Cmd{Key: key, Name: cmd, Args: []interface{}{key, "$", jsonDoc(doc.Properties, numeric)}}
+}
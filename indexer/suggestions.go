@@ -0,0 +1,129 @@
+package indexer
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/RedisLabs/rsbench/stats"
+)
+
+// SuggestionIndexer builds an autocomplete suggestion dictionary from the values of a single document field,
+// and keeps a random sample of the added terms to generate benchmark prefixes from
+type SuggestionIndexer struct {
+	cc          *cluster.Client
+	key         string
+	field       string
+	concurrency int
+	ch          chan redisearch.Document
+	wg          sync.WaitGroup
+	counter     uint64
+	errors      stats.ErrorCounts
+
+	// reservoir sample of the added terms
+	mu         sync.Mutex
+	rng        *rand.Rand
+	seen       int
+	sampleSize int
+	sample     []string
+}
+
+func NewSuggestionIndexer(cc *cluster.Client, key, field string, concurrency int, ch chan redisearch.Document,
+	sampleSize int) *SuggestionIndexer {
+	return &SuggestionIndexer{
+		cc:          cc,
+		key:         key,
+		field:       field,
+		concurrency: concurrency,
+		ch:          ch,
+		rng:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		sampleSize:  sampleSize,
+		sample:      make([]string, 0, sampleSize),
+	}
+}
+
+func (si *SuggestionIndexer) addSample(term string) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	si.seen++
+	if len(si.sample) < si.sampleSize {
+		si.sample = append(si.sample, term)
+	} else if n := si.rng.Intn(si.seen); n < si.sampleSize {
+		si.sample[n] = term
+	}
+}
+
+func (si *SuggestionIndexer) loop() {
+	for doc := range si.ch {
+		v, ok := doc.Properties[si.field]
+		if !ok {
+			continue
+		}
+		term := strings.TrimSpace(fmt.Sprint(v))
+		if term == "" {
+			continue
+		}
+		// repeated terms accumulate score, so frequent values are suggested first
+		if _, err := si.cc.Do(si.key, "FT.SUGADD", si.key, term, 1, "INCR"); err != nil {
+			class := si.errors.Add(err, 1)
+			log.Printf("Error adding suggestion %q (%s): %s\n", term, class, err)
+			continue
+		}
+		atomic.AddUint64(&si.counter, 1)
+		si.addSample(term)
+	}
+	si.wg.Done()
+}
+
+// Start deletes the suggestion dictionary and fills it with the documents read from the channel
+func (si *SuggestionIndexer) Start() {
+	if _, err := si.cc.Do(si.key, "DEL", si.key); err != nil {
+		panic(err)
+	}
+
+	st := time.Now()
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				x := atomic.LoadUint64(&si.counter)
+				log.Printf("Added %d suggestions in %v, rate %.02fsugs/sec, errors: %d %s", x, time.Since(st),
+					float64(x)/time.Since(st).Seconds(), si.errors.Total(), si.errors.String())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < si.concurrency; i++ {
+		si.wg.Add(1)
+		go si.loop()
+	}
+	si.wg.Wait()
+	close(done)
+	errs := si.errors.Total()
+	log.Printf("Suggestions finished: %d added in %v, %d failed (%.02f%%) %s", si.counter, time.Since(st), errs,
+		stats.Rate(errs, errs+si.counter)*100, si.errors.String())
+}
+
+// Returns the number of suggestions added
+func (si *SuggestionIndexer) GetNumIndexed() int {
+	si.wg.Wait()
+	return int(si.counter)
+}
+
+// Sample returns a random sample of the added terms
+func (si *SuggestionIndexer) Sample() []string {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return append([]string(nil), si.sample...)
+}
@@ -0,0 +1,64 @@
+package indexer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// VectorField is a dense vector field, indexed with a FLAT (brute force) or HNSW index
+type VectorField struct {
+	Name string
+	// Algorithm is FLAT or HNSW
+	Algorithm string
+	Dims      int
+	// Metric is the distance metric: L2, IP or COSINE
+	Metric string
+	// HNSW graph parameters, server defaults if 0
+	M              int
+	EfConstruction int
+}
+
+// ParseVectorAlgorithm validates an algorithm and a metric, and returns them in upper case
+func ParseVectorAlgorithm(algorithm, metric string) (string, string, error) {
+	algorithm, metric = strings.ToUpper(algorithm), strings.ToUpper(metric)
+	if algorithm != "FLAT" && algorithm != "HNSW" {
+		return "", "", fmt.Errorf("invalid vector algorithm %q, expected FLAT or HNSW", algorithm)
+	}
+	if metric != "L2" && metric != "IP" && metric != "COSINE" {
+		return "", "", fmt.Errorf("invalid vector metric %q, expected L2, IP or COSINE", metric)
+	}
+	return algorithm, metric, nil
+}
+
+// args returns the FT.CREATE SCHEMA arguments of the field, after its name
+func (f VectorField) args() []interface{} {
+	attrs := []interface{}{"TYPE", "FLOAT32", "DIM", f.Dims, "DISTANCE_METRIC", f.Metric}
+	if f.Algorithm == "HNSW" {
+		if f.M > 0 {
+			attrs = append(attrs, "M", f.M)
+		}
+		if f.EfConstruction > 0 {
+			attrs = append(attrs, "EF_CONSTRUCTION", f.EfConstruction)
+		}
+	}
+	return append([]interface{}{"VECTOR", f.Algorithm, len(attrs)}, attrs...)
+}
+
+// VectorBlob encodes a vector as the little endian float32 blob that hash fields and query parameters hold
+func VectorBlob(vec []float32) []byte {
+	ret := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(ret[i*4:], math.Float32bits(v))
+	}
+	return ret
+}
+
+// fieldValue returns the value a document field is written as. Vectors are written as blobs
+func fieldValue(v interface{}) interface{} {
+	if vec, ok := v.([]float32); ok {
+		return VectorBlob(vec)
+	}
+	return v
+}
@@ -2,31 +2,151 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
 	"github.com/RedisLabs/rsbench/indexer"
 	"github.com/RedisLabs/rsbench/parser"
 )
 
+// untilStopped is the run time of benchmarks that are stopped explicitly
+const untilStopped = 100 * 365 * 24 * time.Hour
+
 func main() {
 
-	reader := flag.String("reader", "", "Reader to use (if set) [wiki_abs|wiki_full|reddit|twitter]")
+	reader := flag.String("reader", "", "Reader to use (if set) [wiki_abs|wiki_full|reddit|twitter|stack|fvecs|bvecs|npy|vecjson|jsonl|csv|synthetic]")
 	path := flag.String("path", "./", "folder/file path")
+	mapping := flag.String("mapping", "", "Field mapping file of the jsonl and csv readers")
+	synthetic := flag.String("synthetic", "", "Model of the synthetic reader, as key=value pairs over the defaults: docs, seed, vocab, zipf, len, lenstddev, text, numeric, tag, geo, tagcard, tagsper, nummin, nummax")
+	maxdocs := flag.Int("maxdocs", 0, "If set, read at most this many documents")
+	skipdocs := flag.Int("skipdocs", 0, "Skip this many documents before reading")
+	every := flag.Int("every", 0, "If set, read only one in this many documents")
+	fraction := flag.Float64("fraction", 0, "If set, read only a random fraction (0-1) of the documents, seeded by -sampleseed")
+	sampleseed := flag.Int64("sampleseed", 1, "Random seed of -fraction")
+	fileseed := flag.Int64("fileseed", 0, "If set, shuffle the order files are read in with this seed, instead of reading them by path")
+	checkpoint := flag.String("checkpoint", "", "If set, write the progress of indexing the files of a folder reader to this checkpoint file")
+	checkpointint := flag.Duration("checkpointint", indexer.DefaultCheckpointInterval, "Checkpoint write interval")
+	resume := flag.Bool("resume", false, "Resume indexing from the -checkpoint file if it exists, skipping the files and documents it completed, and keeping the index")
 	cons := flag.Int("conns", 100, "Concurrent connections to redis")
 	files := flag.Int("rnum", 10, "Number of concurrent file readers")
-	hosts := flag.String("hosts", "localhost:6379", "Redis host(s), comma separated list of ip:port pairs. Use a single value for non cluster version")
+	hosts := flag.String("hosts", "localhost:6379", "Redis host(s), comma separated list of ip:port pairs. For a cluster, the topology is discovered from any of them")
 	index := flag.String("index", "idx", "Index name")
 	query := flag.String("query", "", "Query to benchmark (if set)")
+	workload := flag.String("workload", "", "JSON lines file of weighted queries to benchmark (if set)")
+	offset := flag.Int("offset", DefaultQuerySpec.Offset, "Query results offset")
+	limit := flag.Int("limit", DefaultQuerySpec.Limit, "Number of query results to return")
+	nocontent := flag.Bool("nocontent", DefaultQuerySpec.NoContent, "Return only document ids (NOCONTENT)")
+	verbatim := flag.Bool("verbatim", DefaultQuerySpec.Verbatim, "Do not stem query terms (VERBATIM)")
+	withscores := flag.Bool("withscores", false, "Return document or suggestion scores (WITHSCORES)")
+	inorder := flag.Bool("inorder", false, "Require query terms to appear in order (INORDER)")
+	scorer := flag.String("scorer", DefaultQuerySpec.Scorer, "Query scoring function")
+	language := flag.String("language", "", "Query language, for stemming")
+	expander := flag.String("expander", "", "Query expander")
+	sortby := flag.String("sortby", "", "Sortable field to sort results by")
+	sortdesc := flag.Bool("sortdesc", false, "Sort results in descending order")
+	returnFields := flag.String("return", "", "Comma separated list of fields to return")
+	highlight := flag.String("highlight", "", "Comma separated list of fields to highlight")
+	summarize := flag.String("summarize", "", "Comma separated list of fields to summarize")
+	infields := flag.String("infields", "", "Comma separated list of fields to limit the query to")
+	filter := flag.String("filter", "", "Comma separated list of numeric filters, as field:min:max. Prefix a value with ( to make it exclusive")
+	geofilter := flag.String("geofilter", "", "Geo filter, as field:lon:lat:radius:unit")
+	aggregate := flag.String("aggregate", "", "If set, benchmark FT.AGGREGATE with this pipeline instead of FT.SEARCH, e.g. 'GROUPBY 1 @sub REDUCE COUNT 0 AS num'")
+	withcursor := flag.Bool("withcursor", false, "Read aggregation results with a cursor (WITHCURSOR)")
+	cursorcount := flag.Int("cursorcount", 0, "Number of results per cursor read (server default if 0)")
+	sugkey := flag.String("sugkey", "", "If set, build this suggestion dictionary from the reader instead of an index, and benchmark FT.SUGGET on it. -query is used as a fixed prefix if set")
+	sugfield := flag.String("sugfield", "title", "Document field whose values are added to the suggestion dictionary")
+	sugsample := flag.Int("sugsample", 10000, "Number of suggestion terms to sample benchmark prefixes from")
+	fuzzy := flag.Bool("fuzzy", false, "Fuzzy suggestion prefix matching (FUZZY)")
+	sugmax := flag.Int("sugmax", 5, "Maximum number of suggestions to return")
+	rate := flag.Float64("rate", 0, "If set, send queries open loop at this fixed rate (requests/sec) instead of as fast as possible")
+	arrival := flag.String("arrival", "constant", "Open loop inter-arrival times [constant|poisson]")
+	ramp := flag.Float64("ramp", 0, "If set, ramp the open loop rate up by this many requests/sec every -duration, starting at -rate, until the latency SLO is breached")
+	maxrate := flag.Float64("maxrate", 0, "Highest rate to ramp up to, required with -ramp")
+	slo := flag.Duration("slo", 10*time.Millisecond, "Ramp latency SLO")
+	slopct := flag.Float64("slopct", 99, "Latency percentile the ramp SLO applies to")
+	maxerrors := flag.Float64("maxerrors", 0.01, "Highest error rate (0-1) a ramp step can have")
+	mixed := flag.Bool("mixed", false, "Run the query benchmark while indexing, until -duration seconds after indexing is done. Query stats are segmented by indexing phase")
+	qconns := flag.Int("qconns", 0, "Concurrent query benchmark connections, if different from -conns")
+	phasedocs := flag.Int("phasedocs", 0, "In mixed mode, segment query stats into indexing phases of this many documents")
 	duration := flag.Int("duration", 5, "Duration to run the query benchmark for")
 	csv := flag.Bool("csv", false, "If set, we dump the output report as CSV")
 	chunk := flag.Int("chunk", 1, "Indexing chunk size")
+	pipeline := flag.Int("pipeline", 1, "Indexing chunks every connection sends without waiting for replies. Documents in flight are -conns x -pipeline x -chunk")
+	irate := flag.String("irate", "", "If set, limit the indexing rate (docs/sec) to a constant rate, or a profile: linear:from:to:duration, step:start:step:every or sine:base:amplitude:period")
+	ops := flag.String("ops", "", "If set, run a weighted mix of document operations instead of only adding documents, as op=weight pairs of add, replace, partial, conditional, ftdel and del, e.g. 'add=1,replace=2,ftdel=1'. Without add, the existing index is used")
+	opids := flag.String("opids", "", "Ids that updates and deletes target [doc|sample]: the ids of the documents as read, or ids sampled from documents indexed so far. Defaults to sample if adds go to a newly created index, and to doc otherwise")
+	opsample := flag.Int("opsample", 100000, "Number of indexed ids to sample updates and deletes from, with -opids sample")
+	partialfields := flag.String("partialfields", "", "Comma separated list of fields that partial and conditional updates send (all fields if empty)")
+	ifcond := flag.String("ifcond", "", "Condition of conditional updates (FT.ADD IF), e.g. '@date < 1500000000'")
+	indexmode := flag.String("indexmode", "drop", "What to do with the index before indexing [drop|create|append|skip]: drop and create it, create it if missing, append to it if its fields match the schema, or use it as is")
+	forcedrop := flag.Bool("forcedrop", false, "Drop the index even if it holds documents, with -indexmode drop")
+	storage := flag.String("storage", "ftadd", "How documents are written [ftadd|hash|json]: with FT.ADD, or with HSET or JSON.SET under -prefix, indexed by an FT.CREATE ON HASH or ON JSON index. Use -chunk to pipeline writes")
+	prefix := flag.String("prefix", "doc:", "Key prefix of the documents written with -storage hash or json")
+	retries := flag.Int("retries", indexer.DefaultRetryPolicy.MaxRetries, "Times to retry indexing writes that fail with a retryable error (connection errors, LOADING, BUSY, TRYAGAIN...)")
+	retrybackoff := flag.Duration("retrybackoff", indexer.DefaultRetryPolicy.Backoff, "Backoff before the first retry, doubled on every retry, with jitter")
+	retrymax := flag.Duration("retrymax", indexer.DefaultRetryPolicy.MaxBackoff, "Maximum retry backoff")
+	deadletter := flag.String("deadletter", "", "If set, append the documents that failed to index for good to this JSON lines file, with their error")
+	lagsample := flag.Int("lagsample", 0, "If set, measure the lag until a written document is searchable for one in this many documents")
+	vecfield := flag.String("vecfield", DefaultQuerySpec.VectorField, "Vector field name")
+	vecdim := flag.Int("vecdim", 0, "Vector dimensions, needed to index a vector dataset")
+	vecalgo := flag.String("vecalgo", "HNSW", "Vector index algorithm [FLAT|HNSW]")
+	vecdist := flag.String("vecdist", "L2", "Vector distance metric [L2|IP|COSINE]")
+	vecm := flag.Int("vecm", 0, "HNSW max outgoing edges per node, M (server default if 0)")
+	vecefc := flag.Int("vecefc", 0, "HNSW EF_CONSTRUCTION (server default if 0)")
+	vecefr := flag.Int("vecefr", 0, "HNSW EF_RUNTIME of KNN queries (server default if 0)")
+	knn := flag.Int("knn", 0, "If set, benchmark KNN vector searches for this many nearest neighbors, with -query as an optional prefilter")
+	vecrange := flag.Float64("vecrange", 0, "If set, benchmark vector range searches of this radius, with -query as an optional filter")
+	vecqueries := flag.String("vecqueries", "", "Query vectors file, in the format of -reader, or of -vecformat without a reader")
+	vecformat := flag.String("vecformat", "fvecs", "Query vectors file format, if there is no vector -reader [fvecs|bvecs|npy|vecjson]")
+	vecnq := flag.Int("vecnq", 1000, "Maximum number of query vectors to read (all if 0)")
+	vecgt := flag.String("vecgt", "", "Ground truth ivecs file of the query vectors' nearest neighbors. If not set, KNN recall is computed against an exact search of the -vecbase file")
+	vecbase := flag.String("vecbase", "", "Base vectors file that the exact nearest neighbors are searched in, without -vecgt. Defaults to the -path file of a vector -reader")
+	genqueries := flag.String("genqueries", "", "If set, generate a query workload from the indexed documents and write it to this file, for -workload to replay")
+	genshapes := flag.String("genshapes", strings.Join(QueryShapes, ","), "Comma separated list of shapes of generated queries")
+	gencount := flag.Int("gencount", 100, "Number of generated queries of every shape")
+	gensample := flag.Int("gensample", 10, "Sample one in this many indexed documents to generate queries from")
+	genseed := flag.Int64("genseed", 1, "Random seed of generated queries")
+	iinterval := flag.Duration("iinterval", indexer.DefaultReportInterval, "Indexing progress reporting interval")
+	ireport := flag.String("ireport", "", "If set, write the final indexing summary to this file (CSV if -csv is set, JSON otherwise). Without a query benchmark, it is written to stdout")
+	interval := flag.Duration("interval", time.Second, "Query benchmark reporting interval")
+	timeseries := flag.String("timeseries", "", "If set, write per-interval query benchmark samples to this file (CSV if -csv is set, JSON lines otherwise)")
 
 	flag.Parse()
-	if *reader == "" && *query == "" {
-		panic("Must have query or reader!")
+	if *arrival != "constant" && *arrival != "poisson" {
+		panic("Invalid arrival: " + *arrival)
+	}
+	if *ramp > 0 && (*rate <= 0 || *maxrate < *rate) {
+		panic("Ramp needs a starting -rate and a -maxrate above it!")
+	}
+	if *ramp > 0 && *timeseries != "" {
+		panic("Time series are not written in ramp mode!")
+	}
+	vectorSearch := *knn > 0 || *vecrange > 0
+	queryBench := *query != "" || *workload != "" || *sugkey != "" || vectorSearch
+	if *mixed && (*reader == "" || *sugkey != "" || *ramp > 0 || (*query == "" && *workload == "" && !vectorSearch)) {
+		panic("Mixed mode needs a reader and a query or workload, and can't ramp!")
+	}
+	if *genqueries != "" && (*reader == "" || *sugkey != "" || *mixed || *gensample < 1) {
+		panic("Query generation needs a reader, and -gensample >= 1, and can't run in mixed mode!")
+	}
+	if *reader == "" && *query == "" && *workload == "" && !vectorSearch {
+		panic("Must have query, workload or reader!")
 	}
+	algorithm, metric, err := indexer.ParseVectorAlgorithm(*vecalgo, *vecdist)
+	if err != nil {
+		panic(err)
+	}
+
+	// terms sampled from the suggestion dictionary, to generate prefixes from
+	var prefixes []string
+	// in mixed mode, the indexer runs concurrently with the query benchmark
+	var idx *indexer.Indexer
+	indexDone := make(chan struct{})
 
 	if *reader != "" {
 		var sp indexer.SchemaProvider
@@ -48,34 +168,414 @@ func main() {
 		case "stack":
 			rd = indexer.NewSingleFileReader(*path, indexer.DocumentReaderOpenerFunc(parser.StackExchangeReaderOpen))
 			sp = indexer.SchemaProviderFunc(parser.StackSchema)
+		case "jsonl", "csv":
+			if *mapping == "" {
+				panic("The " + *reader + " reader needs a -mapping")
+			}
+			m, err := parser.LoadMapping(*mapping)
+			if err != nil {
+				panic(err)
+			}
+			var fr *indexer.FolderReader
+			if *reader == "jsonl" {
+				fr = indexer.NewFolderReader(*path, "*.jsonl,*.json", *files, indexer.DocumentReaderOpenerFunc(m.JSONReaderOpen))
+			} else {
+				fr = indexer.NewFolderReader(*path, "*.[ct]sv", *files, indexer.DocumentReaderOpenerFunc(m.CSVReaderOpen))
+			}
+			fr.Exclude(*mapping, *checkpoint, *deadletter)
+			// documents are numbered from 0 in every file, so numbered ids would collide across files
+			if m.ID == "" && len(fr.Files()) > 1 {
+				panic("Reading more than one file needs an id in the -mapping")
+			}
+			rd = fr
+			sp = m
+		case "synthetic":
+			config, err := parser.ParseSyntheticConfig(*synthetic)
+			if err != nil {
+				panic(err)
+			}
+			sr := parser.NewSyntheticReader(config, *files)
+			rd = sr
+			sp = sr
+		case "fvecs", "bvecs", "npy", "vecjson":
+			// documents are numbered from 0 in every file, which is how ground truth refers to a single base file
+			if fi, err := os.Stat(*path); err != nil || fi.IsDir() {
+				panic("Vector readers read a single base file, -path must be a file")
+			}
+			rd = indexer.NewFolderReader(*path, "*."+*reader, *files, vectorOpener(*reader, *vecfield))
+			sp = indexer.SchemaProviderFunc(parser.VectorSchema)
 		default:
 			panic("Inavlid reader: " + *reader)
 		}
 
+		sampling := indexer.Sampling{
+			Skip:     *skipdocs,
+			Max:      *maxdocs,
+			Every:    *every,
+			Fraction: *fraction,
+			FileSeed: *fileseed,
+		}
+		if sampling.Enabled() {
+			if err := sampling.Validate(); err != nil {
+				panic(err)
+			}
+			sr, ok := rd.(indexer.SamplingParser)
+			if !ok {
+				panic("The " + *reader + " reader can't skip, limit or sample documents")
+			}
+			sampling.Seed = *sampleseed
+			sr.SetSampling(sampling)
+		}
+
+		var progress *indexer.Progress
+		var resumed *indexer.Checkpoint
+		if *checkpoint != "" {
+			fr, ok := rd.(*indexer.FolderReader)
+			if !ok || sampling.Enabled() || *sugkey != "" {
+				panic("Checkpoints need a folder reader without sampling, and an index")
+			}
+			if *resume {
+				if resumed, err = indexer.LoadCheckpoint(*checkpoint); err != nil && !os.IsNotExist(err) {
+					panic(err)
+				}
+				if resumed != nil {
+					log.Printf("Resuming from checkpoint %s of %v: %d docs indexed, %d files completed",
+						*checkpoint, resumed.Time, resumed.Docs, len(resumed.Completed))
+				}
+			}
+			progress = indexer.NewProgress(resumed)
+			fr.SetProgress(progress)
+		} else if *resume {
+			panic("Resuming needs a -checkpoint")
+		}
+
 		ch := make(chan redisearch.Document, *cons**chunk)
 
 		if err := rd.Start(ch); err != nil {
 			panic(err)
 		}
 
-		idx := indexer.New(*index, *hosts, *cons, ch, nil, sp, *chunk)
-		idx.Start()
-		if idx.GetNumIndexed() == 0 {
-			panic("No documents indexed!")
+		if *sugkey != "" {
+			cc, err := cluster.New(*hosts, *cons)
+			if err != nil {
+				panic(err)
+			}
+			si := indexer.NewSuggestionIndexer(cc, *sugkey, *sugfield, *cons, ch, *sugsample)
+			si.Start()
+			if si.GetNumIndexed() == 0 {
+				panic("No suggestions added!")
+			}
+			prefixes = si.Sample()
+		} else {
+			idx = indexer.New(*index, *hosts, *cons, ch, nil, sp, *chunk)
+			st, err := indexer.ParseStorage(*storage)
+			if err != nil {
+				panic(err)
+			}
+			idx.SetStorage(st, *prefix)
+			mode, err := indexer.ParseIndexMode(*indexmode)
+			if err != nil {
+				panic(err)
+			}
+			if resumed != nil && mode == indexer.IndexDropCreate {
+				// the resumed run's documents are in the index
+				mode = indexer.IndexCreateIfMissing
+			}
+			idx.SetIndexMode(mode, *forcedrop)
+			if progress != nil {
+				idx.SetCheckpoint(*checkpoint, progress, *checkpointint, resumed)
+			}
+			idx.SetPipelineDepth(*pipeline)
+			idx.SetReportInterval(*iinterval)
+			idx.SetRetryPolicy(indexer.RetryPolicy{
+				MaxRetries: *retries,
+				Backoff:    *retrybackoff,
+				MaxBackoff: *retrymax,
+			})
+			if *deadletter != "" {
+				if err := idx.SetDeadLetters(*deadletter); err != nil {
+					panic(err)
+				}
+			}
+			if vectorOpener(*reader, *vecfield) != nil {
+				if st == indexer.StorageFtAdd || *vecdim <= 0 {
+					panic("Vector datasets need -storage hash or json, and -vecdim")
+				}
+				idx.AddVectorField(indexer.VectorField{
+					Name:           *vecfield,
+					Algorithm:      algorithm,
+					Dims:           *vecdim,
+					Metric:         metric,
+					M:              *vecm,
+					EfConstruction: *vecefc,
+				})
+			}
+			if *lagsample > 0 {
+				idx.SetLagSampling(*lagsample)
+			}
+			if *irate != "" {
+				profile, err := indexer.ParseRateProfile(*irate)
+				if err != nil {
+					panic(err)
+				}
+				idx.SetRateProfile(profile)
+			}
+			if *ops != "" {
+				mix, err := indexer.ParseOpMix(*ops)
+				if err != nil {
+					panic(err)
+				}
+				if mix.Has(indexer.OpConditional) && *ifcond == "" {
+					panic("Conditional updates need -ifcond")
+				}
+				if mix.Has(indexer.OpConditional) && st != indexer.StorageFtAdd {
+					panic("Conditional updates need -storage ftadd")
+				}
+				idx.SetOpMix(mix)
+				idx.SetPartialUpdate(ParseList(*partialfields), *ifcond)
+				// with adds, a dropped index is created anew, and holds none of the documents as read until they
+				// are added
+				fresh := mix.Has(indexer.OpAdd) && mode == indexer.IndexDropCreate
+				if *opids == "" {
+					*opids = "doc"
+					if fresh {
+						*opids = "sample"
+					}
+				} else if *opids == "doc" && fresh {
+					panic("Updates and deletes can't target the ids of the documents as read in a newly created index, use -opids sample or another -indexmode")
+				}
+				switch *opids {
+				case "doc":
+				case "sample":
+					idx.SetIDSampling(*opsample)
+				default:
+					panic("Invalid opids: " + *opids)
+				}
+			}
+			var model *indexer.CorpusModel
+			if *genqueries != "" {
+				model = indexer.NewCorpusModel(sp.Schema(), *gensample, 1000000)
+				idx.SetCorpusModel(model)
+			}
+			if *mixed {
+				idx.SetPhaseDocs(*phasedocs)
+				go func() {
+					idx.Start()
+					close(indexDone)
+				}()
+				// start querying once the index exists
+				<-idx.Ready()
+			} else {
+				idx.Start()
+				if idx.GetNumIndexed() == 0 {
+					panic("No documents indexed!")
+				}
+			}
+			if model != nil {
+				qs, err := GenerateQueries(model, ParseList(*genshapes), *gencount, *genseed)
+				if err != nil {
+					panic(err)
+				}
+				comment := fmt.Sprintf("generated from %d sampled documents", model.Docs())
+				if err := WriteWorkloadFile(*genqueries, qs, comment); err != nil {
+					panic(err)
+				}
+				log.Printf("Wrote %d generated queries to %s", len(qs), *genqueries)
+			}
+			if !*mixed && (*ireport != "" || !queryBench) {
+				dumpIndexSummary(idx, *ireport, *csv)
+			}
 		}
 	}
-	if *query != "" {
+	if queryBench {
+
+		// the query options flags apply to -query, and are the defaults for every query of a -workload
+		spec := DefaultQuerySpec
+		spec.Offset = *offset
+		spec.Limit = *limit
+		spec.NoContent = *nocontent
+		spec.Verbatim = *verbatim
+		spec.WithScores = *withscores
+		spec.InOrder = *inorder
+		spec.Scorer = *scorer
+		spec.Language = *language
+		spec.Expander = *expander
+		spec.SortBy = *sortby
+		spec.SortDesc = *sortdesc
+		spec.Return = ParseList(*returnFields)
+		spec.Highlight = ParseList(*highlight)
+		spec.Summarize = ParseList(*summarize)
+		spec.InFields = ParseList(*infields)
+		var err error
+		if spec.Filters, err = ParseNumericFilters(*filter); err != nil {
+			panic(err)
+		}
+		if spec.GeoFilter, err = ParseGeoFilter(*geofilter); err != nil {
+			panic(err)
+		}
+		spec.Aggregate = *aggregate
+		spec.WithCursor = *withcursor
+		spec.CursorCount = *cursorcount
+		spec.Fuzzy = *fuzzy
+		spec.Max = *sugmax
+		spec.KNN = *knn
+		spec.VectorRange = *vecrange
+		spec.VectorField = *vecfield
+		spec.EfRuntime = *vecefr
+
+		var specs []QuerySpec
+		if *workload != "" {
+			if specs, err = LoadWorkloadFile(*workload, spec); err != nil {
+				panic(err)
+			}
+		} else {
+			spec.Name = *query
+			spec.Query = *query
+			if *sugkey != "" {
+				spec.Suggest = *sugkey
+				spec.Name = "FT.SUGGET " + *sugkey + " " + *query
+			} else if spec.IsVector() {
+				spec.Name = spec.VectorName()
+			}
+			specs = []QuerySpec{spec}
+		}
+
+		// vector searches pick from the query vectors, and KNN searches are checked against their nearest neighbors
+		var vecs [][]float32
+		var truth [][]string
+		maxKNN, hasVector := 0, false
+		for _, s := range specs {
+			hasVector = hasVector || s.IsVector()
+			if s.KNN > maxKNN {
+				maxKNN = s.KNN
+			}
+		}
+		if hasVector {
+			format := *reader
+			if vectorOpener(format, *vecfield) == nil {
+				format = *vecformat
+			}
+			opener := vectorOpener(format, *vecfield)
+			if opener == nil || *vecqueries == "" {
+				panic("Vector searches need -vecqueries in a vector format")
+			}
+			base := *vecbase
+			if base == "" && vectorOpener(*reader, *vecfield) != nil {
+				base = *path
+			}
+			if vecs, truth, err = loadVectorQueries(opener, *vecqueries, *vecfield, *vecnq, maxKNN,
+				*vecgt, base, metric, *prefix); err != nil {
+				panic(err)
+			}
+		}
+
+		qconcurrency := *cons
+		if *qconns > 0 {
+			qconcurrency = *qconns
+		}
+		runTime := time.Second * time.Duration(*duration)
+		if *mixed {
+			// the benchmark is stopped -duration seconds after indexing is done
+			runTime = untilStopped
+		}
+		cc, err := cluster.New(*hosts, qconcurrency)
+		if err != nil {
+			panic(err)
+		}
+		newBench := func(rate float64) (*QueryBenchmark, error) {
+			b, err := NewQueryBenchmark(cc, *index, specs, qconcurrency, runTime, *interval)
+			if err != nil {
+				return nil, err
+			}
+			b.SetPrefixes(prefixes)
+			if vecs != nil {
+				b.SetVectors(vecs, truth)
+			}
+			b.SetRate(rate, *arrival == "poisson")
+			return b, nil
+		}
 
-		client := redisearch.NewClient(*hosts, *index)
+		if *ramp > 0 {
+			report, err := RunRamp(newBench, RampOptions{
+				Start:        *rate,
+				Step:         *ramp,
+				Max:          *maxrate,
+				SLO:          float64(*slo) / float64(time.Millisecond),
+				Percentile:   *slopct,
+				MaxErrorRate: *maxerrors,
+			})
+			if err != nil {
+				panic(err)
+			}
+			if *csv {
+				report.DumpCSV(os.Stdout)
+			} else {
+				report.DumpJson(os.Stdout)
+			}
+			return
+		}
 
-		b := NewQueryBenchmark(client, *query, *cons, time.Second*time.Duration(*duration))
+		b, err := newBench(*rate)
+		if err != nil {
+			panic(err)
+		}
+		if *timeseries != "" {
+			fp, err := os.Create(*timeseries)
+			if err != nil {
+				panic(err)
+			}
+			defer fp.Close()
+			ts, err := NewTimeSeriesWriter(fp, *csv)
+			if err != nil {
+				panic(err)
+			}
+			b.SetTimeSeries(ts)
+		}
+		if *mixed {
+			b.SetPhases(idx.Phase)
+			go func() {
+				<-indexDone
+				time.Sleep(time.Second * time.Duration(*duration))
+				b.Stop()
+			}()
+		}
 		//fmt.Printf("Starting benchmark for %v\n", time.Until(b.endTime))
-		b.Run()
+		if err := b.Run(); err != nil {
+			panic(err)
+		}
 		if *csv {
-			b.DumpCSV(os.Stdout)
+			err = b.DumpCSV(os.Stdout)
 		} else {
-			b.DumpJson(os.Stdout)
+			err = b.DumpJson(os.Stdout)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if *mixed && *ireport != "" {
+			dumpIndexSummary(idx, *ireport, *csv)
 		}
 
 	}
 }
+
+// dumpIndexSummary writes the final indexing summary to a file, or to stdout if path is empty
+func dumpIndexSummary(idx *indexer.Indexer, path string, asCSV bool) {
+	out := os.Stdout
+	if path != "" {
+		fp, err := os.Create(path)
+		if err != nil {
+			panic(err)
+		}
+		defer fp.Close()
+		out = fp
+	}
+	var err error
+	if asCSV {
+		err = idx.DumpCSV(out)
+	} else {
+		err = idx.DumpJson(out)
+	}
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,352 @@
+package parser
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/indexer"
+)
+
+// FieldMapping maps a value of the source data to a document field
+type FieldMapping struct {
+	// Name is the document field name
+	Name string `json:"name"`
+	// Source is the CSV column name (or index, without a header row) or the dotted JSON path of the value,
+	// e.g. "user.name" or "$.tags.0". Defaults to the field name
+	Source string `json:"source"`
+	// Type is text, numeric, tag or geo
+	Type string `json:"type"`
+	// Lon and Lat are the sources of geo fields that keep their coordinates apart. Otherwise the source
+	// holds "lon,lat"
+	Lon string `json:"lon"`
+	Lat string `json:"lat"`
+
+	Sortable  bool    `json:"sortable"`
+	NoIndex   bool    `json:"noindex"`
+	NoStem    bool    `json:"nostem"`
+	Weight    float32 `json:"weight"`
+	Separator string  `json:"separator"`
+}
+
+// Mapping describes how to read documents from JSON lines or CSV/TSV files, and the schema to index them with
+type Mapping struct {
+	// ID is the source of the document id. If empty, documents are numbered in file order starting at 0, which
+	// only makes ids unique in a single file
+	ID string `json:"id"`
+	// Score is the source of the document score, 1 if empty
+	Score  string         `json:"score"`
+	Fields []FieldMapping `json:"fields"`
+
+	// CSV options: the field delimiter ("," by default, "\t" for TSV), and whether the first row names
+	// the columns
+	Delimiter string `json:"delimiter"`
+	NoHeader  bool   `json:"noheader"`
+
+	// Index options
+	NoOffsetVectors bool `json:"nooffsets"`
+	NoFrequencies   bool `json:"nofreqs"`
+	NoFieldFlags    bool `json:"nofields"`
+}
+
+// LoadMapping reads a JSON mapping file
+func LoadMapping(path string) (*Mapping, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	m := &Mapping{}
+	if err := json.NewDecoder(fp).Decode(m); err != nil {
+		return nil, fmt.Errorf("mapping %s: %s", path, err)
+	}
+	if len(m.Fields) == 0 {
+		return nil, fmt.Errorf("mapping %s has no fields", path)
+	}
+	for i := range m.Fields {
+		f := &m.Fields[i]
+		if f.Name == "" {
+			return nil, fmt.Errorf("mapping %s: field %d has no name", path, i)
+		}
+		if f.Source == "" {
+			f.Source = f.Name
+		}
+		switch f.Type {
+		case "text", "numeric", "tag", "geo":
+		default:
+			return nil, fmt.Errorf("mapping %s: field %s has invalid type %q, expected text, numeric, tag or geo",
+				path, f.Name, f.Type)
+		}
+		if (f.Lon == "") != (f.Lat == "") {
+			return nil, fmt.Errorf("mapping %s: field %s needs both lon and lat", path, f.Name)
+		}
+		if len(f.Separator) > 1 {
+			return nil, fmt.Errorf("mapping %s: field %s separator must be a single character", path, f.Name)
+		}
+	}
+	if len(m.Delimiter) > 1 {
+		return nil, fmt.Errorf("mapping %s: delimiter must be a single character", path)
+	}
+	if m.NoHeader {
+		if err := m.checkColumns(); err != nil {
+			return nil, fmt.Errorf("mapping %s: %s", path, err)
+		}
+	}
+	return m, nil
+}
+
+// checkColumns checks that every source is a column index, as they must be without a header row
+func (m *Mapping) checkColumns() error {
+	column := func(name, source string) error {
+		if source == "" {
+			return nil
+		}
+		if i, err := strconv.Atoi(source); err != nil || i < 0 {
+			return fmt.Errorf("%s source %q must be a column index without a header row", name, source)
+		}
+		return nil
+	}
+	if err := column("id", m.ID); err != nil {
+		return err
+	}
+	if err := column("score", m.Score); err != nil {
+		return err
+	}
+	for _, f := range m.Fields {
+		if f.Lon != "" {
+			if err := column("field "+f.Name+" lon", f.Lon); err != nil {
+				return err
+			}
+			if err := column("field "+f.Name+" lat", f.Lat); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := column("field "+f.Name, f.Source); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schema returns the schema of the mapped fields
+func (m *Mapping) Schema() *redisearch.Schema {
+	sc := redisearch.NewSchema(redisearch.Options{
+		NoOffsetVectors: m.NoOffsetVectors,
+		NoFrequencies:   m.NoFrequencies,
+		NoFieldFlags:    m.NoFieldFlags,
+	})
+	for _, f := range m.Fields {
+		switch f.Type {
+		case "text":
+			sc.AddField(redisearch.NewTextFieldOptions(f.Name, redisearch.TextFieldOptions{
+				Weight: f.Weight, Sortable: f.Sortable, NoStem: f.NoStem, NoIndex: f.NoIndex}))
+		case "numeric":
+			sc.AddField(redisearch.NewNumericFieldOptions(f.Name, redisearch.NumericFieldOptions{
+				Sortable: f.Sortable, NoIndex: f.NoIndex}))
+		case "tag":
+			opts := redisearch.TagFieldOptions{Sortable: f.Sortable, NoIndex: f.NoIndex}
+			if f.Separator != "" {
+				opts.Separator = f.Separator[0]
+			}
+			sc.AddField(redisearch.NewTagFieldOptions(f.Name, opts))
+		case "geo":
+			sc.AddField(redisearch.NewGeoField(f.Name))
+		}
+	}
+	return sc
+}
+
+// document builds a document from a lookup function of source values. Missing values are left out
+func (m *Mapping) document(n int, lookup func(source string) (interface{}, bool)) (redisearch.Document, error) {
+	id := strconv.Itoa(n)
+	if m.ID != "" {
+		v, ok := lookup(m.ID)
+		if !ok {
+			return redisearch.Document{}, fmt.Errorf("document %d has no id %s", n, m.ID)
+		}
+		id = valueString(v, ",")
+	}
+	score := float32(1)
+	if m.Score != "" {
+		if v, ok := lookup(m.Score); ok {
+			s, err := strconv.ParseFloat(valueString(v, ","), 32)
+			if err != nil {
+				return redisearch.Document{}, fmt.Errorf("document %s has an invalid score: %s", id, err)
+			}
+			score = float32(s)
+		}
+	}
+
+	doc := redisearch.NewDocument(id, score)
+	for _, f := range m.Fields {
+		if f.Type == "geo" && f.Lon != "" {
+			lon, ok1 := lookup(f.Lon)
+			lat, ok2 := lookup(f.Lat)
+			if ok1 && ok2 {
+				doc = doc.Set(f.Name, valueString(lon, ",")+","+valueString(lat, ","))
+			}
+			continue
+		}
+		v, ok := lookup(f.Source)
+		if !ok {
+			continue
+		}
+		if f.Type == "numeric" {
+			if num, isNum := v.(json.Number); isNum {
+				doc = doc.Set(f.Name, num)
+				continue
+			}
+		}
+		sep := ","
+		if f.Separator != "" {
+			sep = f.Separator
+		}
+		doc = doc.Set(f.Name, valueString(v, sep))
+	}
+	return doc, nil
+}
+
+// valueString formats a source value as a field value. Arrays, e.g. of tags, are joined with sep. JSON numbers
+// keep their text, so that large integer ids don't lose precision
+func valueString(v interface{}, sep string) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.Number:
+		return val.String()
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case []interface{}:
+		parts := make([]string, len(val))
+		for i, e := range val {
+			parts[i] = valueString(e, sep)
+		}
+		return strings.Join(parts, sep)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// MappedJSONReader reads JSON lines documents with a mapping
+type MappedJSONReader struct {
+	m   *Mapping
+	dec *json.Decoder
+	n   int
+}
+
+// JSONReaderOpen opens JSON lines files with the mapping. Numbers are decoded as json.Number
+func (m *Mapping) JSONReaderOpen(r io.Reader) (indexer.DocumentReader, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &MappedJSONReader{m: m, dec: dec}, nil
+}
+
+// jsonPath looks up a dotted path in a decoded JSON value. Numeric path elements index arrays
+func jsonPath(obj interface{}, path string) (interface{}, bool) {
+	path = strings.TrimPrefix(strings.TrimPrefix(path, "$"), ".")
+	cur := obj
+	for _, key := range strings.Split(path, ".") {
+		switch val := cur.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if cur, ok = val[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(val) {
+				return nil, false
+			}
+			cur = val[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, cur != nil
+}
+
+// Read returns the next document. Documents that can not be mapped are logged and skipped
+func (r *MappedJSONReader) Read() (doc redisearch.Document, err error) {
+	for {
+		var obj map[string]interface{}
+		if err = r.dec.Decode(&obj); err != nil {
+			return
+		}
+		r.n++
+		if doc, err = r.m.document(r.n-1, func(source string) (interface{}, bool) {
+			return jsonPath(obj, source)
+		}); err == nil {
+			return
+		}
+		log.Printf("Skipping document: %s", err)
+	}
+}
+
+// MappedCSVReader reads CSV or TSV documents with a mapping
+type MappedCSVReader struct {
+	m       *Mapping
+	r       *csv.Reader
+	columns map[string]int
+	n       int
+}
+
+// CSVReaderOpen opens CSV or TSV files with the mapping. Sources are column names, or column indexes if the
+// files have no header row
+func (m *Mapping) CSVReaderOpen(r io.Reader) (indexer.DocumentReader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	if m.Delimiter != "" {
+		cr.Comma = rune(m.Delimiter[0])
+	}
+	ret := &MappedCSVReader{m: m, r: cr, columns: map[string]int{}}
+	if !m.NoHeader {
+		header, err := cr.Read()
+		if err != nil {
+			return nil, err
+		}
+		for i, name := range header {
+			ret.columns[strings.TrimSpace(name)] = i
+		}
+	}
+	return ret, nil
+}
+
+// Read returns the next document. Documents that can not be mapped are logged and skipped
+func (r *MappedCSVReader) Read() (doc redisearch.Document, err error) {
+	for {
+		var row []string
+		if row, err = r.r.Read(); err != nil {
+			return
+		}
+		r.n++
+		if doc, err = r.m.document(r.n-1, func(source string) (interface{}, bool) {
+			return r.column(row, source)
+		}); err == nil {
+			return
+		}
+		log.Printf("Skipping document: %s", err)
+	}
+}
+
+// column returns the value of a column of a row, by name or by index if there is no header row
+func (r *MappedCSVReader) column(row []string, source string) (interface{}, bool) {
+	i, ok := r.columns[source]
+	if r.m.NoHeader {
+		var err error
+		i, err = strconv.Atoi(source)
+		ok = err == nil
+	}
+	if !ok || i < 0 || i >= len(row) || row[i] == "" {
+		return nil, false
+	}
+	return row[i], true
+}
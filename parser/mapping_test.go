@@ -0,0 +1,268 @@
+package parser
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/indexer"
+)
+
+func TestJSONPath(t *testing.T) {
+	var obj interface{}
+	if err := json.Unmarshal([]byte(`{"user": {"name": "bob", "tags": ["a", "b"], "none": null},
+		"points": [{"x": 1}, {"x": 2}], "n": 3.5}`), &obj); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path   string
+		want   interface{}
+		wantOK bool
+	}{
+		{"n", 3.5, true},
+		{"user.name", "bob", true},
+		{"$.user.name", "bob", true},
+		{"user.tags", []interface{}{"a", "b"}, true},
+		{"user.tags.1", "b", true},
+		{"points.1.x", 2.0, true},
+		{"$.points.0.x", 1.0, true},
+		{"missing", nil, false},
+		{"user.missing", nil, false},
+		{"user.none", nil, false},
+		{"user.tags.2", nil, false},
+		{"user.tags.-1", nil, false},
+		{"user.tags.first", nil, false},
+		{"user.name.first", nil, false},
+	}
+	for _, tc := range tests {
+		got, ok := jsonPath(obj, tc.path)
+		if ok != tc.wantOK || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("jsonPath(%q) = %v, %v, want %v, %v", tc.path, got, ok, tc.want, tc.wantOK)
+		}
+	}
+}
+
+func TestValueString(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		sep  string
+		want string
+	}{
+		{"text", ",", "text"},
+		{42.0, ",", "42"},
+		{json.Number("1234567890123456789"), ",", "1234567890123456789"},
+		{json.Number("0.1"), ",", "0.1"},
+		{-0.25, ",", "-0.25"},
+		{1e21, ",", "1000000000000000000000"},
+		{true, ",", "true"},
+		{nil, ",", ""},
+		{[]interface{}{"a", 1.5, "c"}, ",", "a,1.5,c"},
+		{[]interface{}{"a", "b"}, "|", "a|b"},
+		{[]interface{}{}, ",", ""},
+		{[]interface{}{[]interface{}{"a", "b"}, "c"}, ";", "a;b;c"},
+	}
+	for _, tc := range tests {
+		if got := valueString(tc.v, tc.sep); got != tc.want {
+			t.Errorf("valueString(%#v, %q) = %q, want %q", tc.v, tc.sep, got, tc.want)
+		}
+	}
+}
+
+func TestLoadMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		mapping string
+		// wantErr is a substring of the expected error, or empty if the mapping is valid
+		wantErr string
+	}{
+		{"valid", `{"id": "id", "fields": [{"name": "title", "type": "text"},
+			{"name": "loc", "type": "geo", "lon": "lon", "lat": "lat"}], "delimiter": "\t"}`, ""},
+		{"valid without header", `{"id": "0", "score": "1", "noheader": true, "fields": [
+			{"name": "title", "type": "text", "source": "2"},
+			{"name": "loc", "type": "geo", "lon": "3", "lat": "4"}]}`, ""},
+		{"bad json", `{"fields": [`, "unexpected EOF"},
+		{"no fields", `{"id": "id"}`, "has no fields"},
+		{"no name", `{"fields": [{"type": "text"}]}`, "field 0 has no name"},
+		{"bad type", `{"fields": [{"name": "title", "type": "string"}]}`, `invalid type "string"`},
+		{"lon without lat", `{"fields": [{"name": "loc", "type": "geo", "lon": "lon"}]}`, "needs both lon and lat"},
+		{"long separator", `{"fields": [{"name": "tags", "type": "tag", "separator": ";;"}]}`,
+			"separator must be a single character"},
+		{"long delimiter", `{"fields": [{"name": "title", "type": "text"}], "delimiter": "::"}`,
+			"delimiter must be a single character"},
+		{"named source without header", `{"noheader": true, "fields": [{"name": "title", "type": "text"}]}`,
+			`field title source "title" must be a column index`},
+		{"named id without header", `{"id": "id", "noheader": true,
+			"fields": [{"name": "title", "type": "text", "source": "1"}]}`, `id source "id" must be a column index`},
+		{"named lat without header", `{"noheader": true,
+			"fields": [{"name": "loc", "type": "geo", "lon": "0", "lat": "lat"}]}`, `field loc lat source "lat"`},
+		{"negative column", `{"noheader": true, "fields": [{"name": "title", "type": "text", "source": "-1"}]}`,
+			`field title source "-1" must be a column index`},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "mapping.json")
+			if err := os.WriteFile(path, []byte(tc.mapping), 0644); err != nil {
+				t.Fatal(err)
+			}
+			m, err := LoadMapping(path)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			// sources default to the field names
+			if !m.NoHeader && (m.Fields[0].Source != "title" || m.Delimiter != "\t") {
+				t.Errorf("mapping = %+v", m)
+			}
+		})
+	}
+
+	if _, err := LoadMapping(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loading a missing mapping succeeded")
+	}
+}
+
+// readAll reads every document of a file, with Ids, Scores and Properties only
+func readAll(t *testing.T, open indexer.DocumentReaderOpenerFunc, data string) []redisearch.Document {
+	rd, err := open(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := []redisearch.Document{}
+	for {
+		doc, err := rd.Read()
+		if err == io.EOF {
+			return ret
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, redisearch.Document{Id: doc.Id, Score: doc.Score, Properties: doc.Properties})
+	}
+}
+
+func TestMappedJSONReader(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Mapping
+		data string
+		want []redisearch.Document
+	}{
+		{
+			name: "numbered",
+			m:    Mapping{Fields: []FieldMapping{{Name: "title", Source: "title", Type: "text"}}},
+			data: `{"title": "a"}` + "\n" + `{"title": "b"}` + "\n",
+			want: []redisearch.Document{
+				{Id: "0", Score: 1, Properties: map[string]interface{}{"title": "a"}},
+				{Id: "1", Score: 1, Properties: map[string]interface{}{"title": "b"}},
+			},
+		},
+		{
+			name: "id and score",
+			m: Mapping{ID: "id", Score: "meta.score", Fields: []FieldMapping{
+				{Name: "title", Source: "title", Type: "text"},
+				{Name: "n", Source: "n", Type: "numeric"},
+				{Name: "tags", Source: "tags", Type: "tag", Separator: "|"},
+				{Name: "user", Source: "$.user.name", Type: "text"},
+			}},
+			data: `{"id": "a", "meta": {"score": 0.5}, "title": "x", "n": 3, "tags": ["p", "q"], "user": {"name": "bob"}}
+{"id": 7, "title": "y", "n": "4"}`,
+			want: []redisearch.Document{
+				{Id: "a", Score: 0.5, Properties: map[string]interface{}{
+					"title": "x", "n": json.Number("3"), "tags": "p|q", "user": "bob"}},
+				{Id: "7", Score: 1, Properties: map[string]interface{}{"title": "y", "n": "4"}},
+			},
+		},
+		{
+			name: "missing ids are skipped",
+			m:    Mapping{ID: "id", Fields: []FieldMapping{{Name: "title", Source: "title", Type: "text"}}},
+			data: `{"title": "a"}` + "\n" + `{"id": null, "title": "b"}` + "\n" + `{"id": "c", "title": "c"}` + "\n",
+			want: []redisearch.Document{
+				{Id: "c", Score: 1, Properties: map[string]interface{}{"title": "c"}},
+			},
+		},
+		{
+			name: "large integer ids",
+			m:    Mapping{ID: "id", Fields: []FieldMapping{{Name: "n", Source: "n", Type: "numeric"}}},
+			data: `{"id": 1234567890123456789, "n": 9007199254740993}` + "\n" + `{"id": 1234567890123456788}` + "\n",
+			want: []redisearch.Document{
+				{Id: "1234567890123456789", Score: 1, Properties: map[string]interface{}{
+					"n": json.Number("9007199254740993")}},
+				{Id: "1234567890123456788", Score: 1, Properties: map[string]interface{}{}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := readAll(t, tc.m.JSONReaderOpen, tc.data); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("read %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMappedCSVReader(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Mapping
+		data string
+		want []redisearch.Document
+	}{
+		{
+			name: "header",
+			m: Mapping{ID: "id", Score: "score", Fields: []FieldMapping{
+				{Name: "title", Source: "title", Type: "text"},
+				{Name: "n", Source: "n", Type: "numeric"},
+			}},
+			data: "id,title,score,n\n1,hello,0.5,3\n2,world,,\n",
+			want: []redisearch.Document{
+				{Id: "1", Score: 0.5, Properties: map[string]interface{}{"title": "hello", "n": "3"}},
+				{Id: "2", Score: 1, Properties: map[string]interface{}{"title": "world"}},
+			},
+		},
+		{
+			name: "no header",
+			m: Mapping{ID: "0", NoHeader: true, Delimiter: "\t", Fields: []FieldMapping{
+				{Name: "title", Source: "1", Type: "text"},
+				{Name: "loc", Type: "geo", Lon: "2", Lat: "3"},
+			}},
+			data: "a\thello\t1.5\t2.5\nb\tworld\n",
+			want: []redisearch.Document{
+				{Id: "a", Score: 1, Properties: map[string]interface{}{"title": "hello", "loc": "1.5,2.5"}},
+				{Id: "b", Score: 1, Properties: map[string]interface{}{"title": "world"}},
+			},
+		},
+		{
+			name: "missing ids are skipped",
+			m:    Mapping{ID: "id", Fields: []FieldMapping{{Name: "title", Source: "title", Type: "text"}}},
+			data: "title,id\na,\nb\nc,3\n",
+			want: []redisearch.Document{
+				{Id: "3", Score: 1, Properties: map[string]interface{}{"title": "c"}},
+			},
+		},
+		{
+			name: "large integer ids",
+			m:    Mapping{ID: "id", Fields: []FieldMapping{{Name: "title", Source: "title", Type: "text"}}},
+			data: "id,title\n1234567890123456789,a\n1234567890123456788,b\n",
+			want: []redisearch.Document{
+				{Id: "1234567890123456789", Score: 1, Properties: map[string]interface{}{"title": "a"}},
+				{Id: "1234567890123456788", Score: 1, Properties: map[string]interface{}{"title": "b"}},
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := readAll(t, tc.m.CSVReaderOpen, tc.data); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("read %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package parser
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+)
+
+// SyntheticConfig is the model of synthetic documents
+type SyntheticConfig struct {
+	// Docs is the number of documents to generate
+	Docs int
+	Seed int64
+	// Vocab is the number of distinct words, drawn from a Zipfian distribution with exponent Zipf (> 1)
+	Vocab int
+	Zipf  float64
+	// LenMean and LenStdDev are the mean and standard deviation of the log-normal number of words of every text
+	// field. A zero LenStdDev makes all the text fields LenMean words long
+	LenMean   float64
+	LenStdDev float64
+
+	TextFields    int
+	NumericFields int
+	TagFields     int
+	GeoFields     int
+
+	// TagCardinality is the number of distinct values of every tag field, drawn from the same Zipfian
+	// distribution as words. Every document has TagsPerDoc values per tag field
+	TagCardinality int
+	TagsPerDoc     int
+	// NumericMin and NumericMax are the range of the uniformly distributed integer numeric values
+	NumericMin int64
+	NumericMax int64
+}
+
+// DefaultSyntheticConfig is a corpus of short English-like documents
+var DefaultSyntheticConfig = SyntheticConfig{
+	Docs:           100000,
+	Seed:           1,
+	Vocab:          100000,
+	Zipf:           1.1,
+	LenMean:        100,
+	LenStdDev:      50,
+	TextFields:     1,
+	NumericFields:  1,
+	TagFields:      1,
+	TagCardinality: 1000,
+	TagsPerDoc:     1,
+	NumericMin:     0,
+	NumericMax:     1000000,
+}
+
+// ParseSyntheticConfig parses a comma separated list of key=value pairs over the defaults, e.g.
+// "docs=1000000,vocab=50000,tagcard=1000000". Keys are docs, seed, vocab, zipf, len, lenstddev, text,
+// numeric, tag, geo, tagcard, tagsper, nummin and nummax
+func ParseSyntheticConfig(s string) (SyntheticConfig, error) {
+	c := DefaultSyntheticConfig
+	if s == "" {
+		return c, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return c, fmt.Errorf("invalid synthetic option %q, expected key=value", part)
+		}
+		var err error
+		switch kv[0] {
+		case "docs":
+			c.Docs, err = strconv.Atoi(kv[1])
+		case "seed":
+			c.Seed, err = strconv.ParseInt(kv[1], 10, 64)
+		case "vocab":
+			c.Vocab, err = strconv.Atoi(kv[1])
+		case "zipf":
+			c.Zipf, err = strconv.ParseFloat(kv[1], 64)
+		case "len":
+			c.LenMean, err = strconv.ParseFloat(kv[1], 64)
+		case "lenstddev":
+			c.LenStdDev, err = strconv.ParseFloat(kv[1], 64)
+		case "text":
+			c.TextFields, err = strconv.Atoi(kv[1])
+		case "numeric":
+			c.NumericFields, err = strconv.Atoi(kv[1])
+		case "tag":
+			c.TagFields, err = strconv.Atoi(kv[1])
+		case "geo":
+			c.GeoFields, err = strconv.Atoi(kv[1])
+		case "tagcard":
+			c.TagCardinality, err = strconv.Atoi(kv[1])
+		case "tagsper":
+			c.TagsPerDoc, err = strconv.Atoi(kv[1])
+		case "nummin":
+			c.NumericMin, err = strconv.ParseInt(kv[1], 10, 64)
+		case "nummax":
+			c.NumericMax, err = strconv.ParseInt(kv[1], 10, 64)
+		default:
+			return c, fmt.Errorf("invalid synthetic option %q", kv[0])
+		}
+		if err != nil {
+			return c, fmt.Errorf("invalid synthetic option %q: %s", part, err)
+		}
+	}
+	if c.Zipf <= 1 || c.Vocab < 1 || c.TagCardinality < 1 || c.LenMean < 1 || c.NumericMax < c.NumericMin {
+		return c, fmt.Errorf("invalid synthetic model: zipf must be > 1, vocab, tagcard and len >= 1, and nummax >= nummin")
+	}
+	return c, nil
+}
+
+// syllables make up the synthetic words, so that they look like words to the tokenizer and stemmer
+var syllables = []string{
+	"ba", "ce", "di", "fo", "gu", "ha", "je", "ki", "lo", "mu", "na", "pe", "qui", "ro", "su", "ta",
+	"ve", "wi", "xo", "yu", "za", "bri", "cla", "dro", "fle", "gri", "plo", "stu", "tra", "vin", "mor", "len",
+}
+
+// SyntheticWord returns the word of the given vocabulary rank. Ranks map to distinct words
+func SyntheticWord(rank int) string {
+	var sb strings.Builder
+	for {
+		sb.WriteString(syllables[rank%len(syllables)])
+		rank /= len(syllables)
+		if rank == 0 {
+			return sb.String()
+		}
+		rank--
+	}
+}
+
+// SyntheticTag returns the tag value of the given rank
+func SyntheticTag(rank int) string {
+	return "tag" + strconv.Itoa(rank)
+}
+
+// SyntheticReader generates documents from a seeded model. The documents are reproducible for the same
+// config and number of workers: worker w generates documents w, w+workers, w+2*workers... from its own
+// seeded random source
+type SyntheticReader struct {
+	config  SyntheticConfig
+	workers int
+	stopch  chan struct{}
+	once    sync.Once
+}
+
+func NewSyntheticReader(config SyntheticConfig, workers int) *SyntheticReader {
+	return &SyntheticReader{
+		config:  config,
+		workers: workers,
+		stopch:  make(chan struct{}),
+	}
+}
+
+func textField(i int) string    { return "text" + strconv.Itoa(i) }
+func numericField(i int) string { return "num" + strconv.Itoa(i) }
+func tagField(i int) string     { return "tag" + strconv.Itoa(i) }
+func geoField(i int) string     { return "geo" + strconv.Itoa(i) }
+
+// Schema returns the schema of the generated fields: text0..n, num0..n, tag0..n and geo0..n
+func (sr *SyntheticReader) Schema() *redisearch.Schema {
+	sc := redisearch.NewSchema(redisearch.DefaultOptions)
+	for i := 0; i < sr.config.TextFields; i++ {
+		sc.AddField(redisearch.NewTextField(textField(i)))
+	}
+	for i := 0; i < sr.config.NumericFields; i++ {
+		sc.AddField(redisearch.NewNumericFieldOptions(numericField(i), redisearch.NumericFieldOptions{Sortable: true}))
+	}
+	for i := 0; i < sr.config.TagFields; i++ {
+		sc.AddField(redisearch.NewTagField(tagField(i)))
+	}
+	for i := 0; i < sr.config.GeoFields; i++ {
+		sc.AddField(redisearch.NewGeoField(geoField(i)))
+	}
+	return sc
+}
+
+// generator generates the documents of a single worker
+type generator struct {
+	c     SyntheticConfig
+	rng   *rand.Rand
+	words *rand.Zipf
+	tags  *rand.Zipf
+	// log-normal length parameters
+	mu, sigma float64
+}
+
+func newGenerator(c SyntheticConfig, seed int64) *generator {
+	rng := rand.New(rand.NewSource(seed))
+	g := &generator{
+		c:     c,
+		rng:   rng,
+		words: rand.NewZipf(rng, c.Zipf, 1, uint64(c.Vocab-1)),
+		tags:  rand.NewZipf(rng, c.Zipf, 1, uint64(c.TagCardinality-1)),
+	}
+	if c.LenStdDev > 0 {
+		g.sigma = math.Sqrt(math.Log(1 + (c.LenStdDev*c.LenStdDev)/(c.LenMean*c.LenMean)))
+		g.mu = math.Log(c.LenMean) - g.sigma*g.sigma/2
+	}
+	return g
+}
+
+func (g *generator) length() int {
+	if g.sigma == 0 {
+		return int(g.c.LenMean)
+	}
+	n := int(math.Round(math.Exp(g.mu + g.sigma*g.rng.NormFloat64())))
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (g *generator) text() string {
+	n := g.length()
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		sb.WriteString(SyntheticWord(int(g.words.Uint64())))
+	}
+	return sb.String()
+}
+
+func (g *generator) document(n int) redisearch.Document {
+	doc := redisearch.NewDocument(strconv.Itoa(n), 1)
+	for i := 0; i < g.c.TextFields; i++ {
+		doc = doc.Set(textField(i), g.text())
+	}
+	for i := 0; i < g.c.NumericFields; i++ {
+		doc = doc.Set(numericField(i), g.c.NumericMin+g.rng.Int63n(g.c.NumericMax-g.c.NumericMin+1))
+	}
+	for i := 0; i < g.c.TagFields; i++ {
+		tags := make([]string, g.c.TagsPerDoc)
+		for j := range tags {
+			tags[j] = SyntheticTag(int(g.tags.Uint64()))
+		}
+		doc = doc.Set(tagField(i), strings.Join(tags, ","))
+	}
+	for i := 0; i < g.c.GeoFields; i++ {
+		doc = doc.Set(geoField(i), fmt.Sprintf("%.6f,%.6f", g.rng.Float64()*360-180, g.rng.Float64()*170-85))
+	}
+	return doc
+}
+
+func (sr *SyntheticReader) Start(ch chan<- redisearch.Document) error {
+	wg := sync.WaitGroup{}
+	for w := 0; w < sr.workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			g := newGenerator(sr.config, sr.config.Seed*int64(sr.workers)+int64(w))
+			for n := w; n < sr.config.Docs; n += sr.workers {
+				select {
+				case <-sr.stopch:
+					return
+				case ch <- g.document(n):
+				}
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		log.Printf("Synthetic reader generated %d docs", sr.config.Docs)
+		close(ch)
+	}()
+	return nil
+}
+
+func (sr *SyntheticReader) Stop() {
+	sr.once.Do(func() {
+		close(sr.stopch)
+	})
+}
@@ -0,0 +1,245 @@
+package parser
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/indexer"
+)
+
+// VectorSchema is the schema of vector datasets. It has no fields of its own: the vector field is added by the
+// indexer, with the dimensions and index parameters of the benchmark
+func VectorSchema() *redisearch.Schema {
+	return redisearch.NewSchema(redisearch.DefaultOptions)
+}
+
+// VectorReader reads dense vectors from a binary vectors file into a single field. Documents are numbered in
+// file order starting at 0, which is how ground truth files refer to them
+type VectorReader struct {
+	field string
+	next  func() ([]float32, error)
+	n     int
+}
+
+func (vr *VectorReader) Read() (doc redisearch.Document, err error) {
+	var vec []float32
+	if vec, err = vr.next(); err != nil {
+		return
+	}
+	doc = redisearch.NewDocument(strconv.Itoa(vr.n), 1).Set(vr.field, vec)
+	vr.n++
+	return
+}
+
+// readDim reads the dimension that prefixes every vector of an fvecs, bvecs or ivecs file
+func readDim(r io.Reader) (int, error) {
+	var dim int32
+	if err := binary.Read(r, binary.LittleEndian, &dim); err != nil {
+		return 0, err
+	}
+	if dim <= 0 {
+		return 0, fmt.Errorf("invalid vector dimension %d", dim)
+	}
+	return int(dim), nil
+}
+
+// FvecsReaderOpener opens fvecs files, of float32 vectors
+func FvecsReaderOpener(field string) indexer.DocumentReaderOpenerFunc {
+	return func(r io.Reader) (indexer.DocumentReader, error) {
+		br := bufio.NewReader(r)
+		return &VectorReader{field: field, next: func() ([]float32, error) {
+			dim, err := readDim(br)
+			if err != nil {
+				return nil, err
+			}
+			vec := make([]float32, dim)
+			if err := binary.Read(br, binary.LittleEndian, vec); err != nil {
+				return nil, err
+			}
+			return vec, nil
+		}}, nil
+	}
+}
+
+// BvecsReaderOpener opens bvecs files, of uint8 vectors
+func BvecsReaderOpener(field string) indexer.DocumentReaderOpenerFunc {
+	return func(r io.Reader) (indexer.DocumentReader, error) {
+		br := bufio.NewReader(r)
+		return &VectorReader{field: field, next: func() ([]float32, error) {
+			dim, err := readDim(br)
+			if err != nil {
+				return nil, err
+			}
+			buf := make([]byte, dim)
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return nil, err
+			}
+			vec := make([]float32, dim)
+			for i, b := range buf {
+				vec[i] = float32(b)
+			}
+			return vec, nil
+		}}, nil
+	}
+}
+
+var (
+	npyMagic   = "\x93NUMPY"
+	npyDescr   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order':\s*True`)
+	npyShape   = regexp.MustCompile(`'shape':\s*\((\d+),\s*(\d+)\)`)
+)
+
+// NpyReaderOpener opens npy files of a 2D little endian float32 or float64 array, with a vector per row
+func NpyReaderOpener(field string) indexer.DocumentReaderOpenerFunc {
+	return func(r io.Reader) (indexer.DocumentReader, error) {
+		br := bufio.NewReader(r)
+		preamble := make([]byte, len(npyMagic)+2)
+		if _, err := io.ReadFull(br, preamble); err != nil {
+			return nil, err
+		}
+		if string(preamble[:len(npyMagic)]) != npyMagic {
+			return nil, fmt.Errorf("not an npy file")
+		}
+		var headerLen uint32
+		if major := preamble[len(npyMagic)]; major == 1 {
+			var l uint16
+			if err := binary.Read(br, binary.LittleEndian, &l); err != nil {
+				return nil, err
+			}
+			headerLen = uint32(l)
+		} else if err := binary.Read(br, binary.LittleEndian, &headerLen); err != nil {
+			return nil, err
+		}
+		header := make([]byte, headerLen)
+		if _, err := io.ReadFull(br, header); err != nil {
+			return nil, err
+		}
+
+		descr := npyDescr.FindSubmatch(header)
+		shape := npyShape.FindSubmatch(header)
+		if descr == nil || shape == nil || npyFortran.Match(header) {
+			return nil, fmt.Errorf("unsupported npy header %q, expected a 2D C order array", header)
+		}
+		rows, _ := strconv.Atoi(string(shape[1]))
+		dim, _ := strconv.Atoi(string(shape[2]))
+		var size int
+		switch string(descr[1]) {
+		case "<f4":
+			size = 4
+		case "<f8":
+			size = 8
+		default:
+			return nil, fmt.Errorf("unsupported npy type %s, expected <f4 or <f8", descr[1])
+		}
+
+		buf := make([]byte, dim*size)
+		read := 0
+		return &VectorReader{field: field, next: func() ([]float32, error) {
+			if read == rows {
+				return nil, io.EOF
+			}
+			if _, err := io.ReadFull(br, buf); err != nil {
+				return nil, err
+			}
+			read++
+			vec := make([]float32, dim)
+			for i := range vec {
+				if size == 4 {
+					vec[i] = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+				} else {
+					vec[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(buf[i*8:])))
+				}
+			}
+			return vec, nil
+		}}, nil
+	}
+}
+
+// VectorJSONReader reads JSON lines documents with an embedding array. The "id" attribute is the document id,
+// the vector is read from the attribute named after the vector field, and other string, number and boolean
+// attributes are kept as fields
+type VectorJSONReader struct {
+	dec   *json.Decoder
+	field string
+	n     int
+}
+
+// VectorJSONReaderOpener opens JSON lines files of documents with embeddings. Numbers are decoded as
+// json.Number, so that large integer ids keep their text
+func VectorJSONReaderOpener(field string) indexer.DocumentReaderOpenerFunc {
+	return func(r io.Reader) (indexer.DocumentReader, error) {
+		dec := json.NewDecoder(r)
+		dec.UseNumber()
+		return &VectorJSONReader{dec: dec, field: field}, nil
+	}
+}
+
+func (vr *VectorJSONReader) Read() (doc redisearch.Document, err error) {
+	var obj map[string]interface{}
+	if err = vr.dec.Decode(&obj); err != nil {
+		return
+	}
+	vr.n++
+	// numeric ids keep their text, as ground truth files refer to them
+	id := valueString(obj["id"], ",")
+	if obj["id"] == nil {
+		// documents without ids are numbered like binary vectors
+		id = strconv.Itoa(vr.n - 1)
+	}
+	arr, ok := obj[vr.field].([]interface{})
+	if !ok {
+		return doc, fmt.Errorf("document %s has no %s array", id, vr.field)
+	}
+	vec := make([]float32, len(arr))
+	for i, v := range arr {
+		num, ok := v.(json.Number)
+		f, err := num.Float64()
+		if !ok || err != nil {
+			return doc, fmt.Errorf("document %s has a non numeric %s value", id, vr.field)
+		}
+		vec[i] = float32(f)
+	}
+
+	doc = redisearch.NewDocument(id, 1).Set(vr.field, vec)
+	for k, v := range obj {
+		if k == "id" || k == vr.field {
+			continue
+		}
+		switch v.(type) {
+		case string, json.Number, bool:
+			doc = doc.Set(k, v)
+		}
+	}
+	return
+}
+
+// ReadGroundTruth reads an ivecs file of the ids of the exact nearest neighbors of every query vector
+func ReadGroundTruth(r io.Reader) ([][]string, error) {
+	br := bufio.NewReader(r)
+	ret := [][]string{}
+	for {
+		dim, err := readDim(br)
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ids := make([]int32, dim)
+		if err := binary.Read(br, binary.LittleEndian, ids); err != nil {
+			return nil, err
+		}
+		row := make([]string, dim)
+		for i, id := range ids {
+			row[i] = strconv.Itoa(int(id))
+		}
+		ret = append(ret, row)
+	}
+}
@@ -0,0 +1,51 @@
+package parser
+
+import (
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVectorJSONReader(t *testing.T) {
+	data := `{"id": 1234567890123456789, "emb": [1, 0.5], "year": 2020, "tag": "a"}
+{"id": 1234567890123456788, "emb": [2, -1]}
+{"emb": [3, 3]}
+{"id": "x", "emb": [1, "a"]}`
+	rd, err := VectorJSONReaderOpener("emb")(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		id    string
+		props map[string]interface{}
+		// wantErr is set if the document is invalid
+		wantErr bool
+	}{
+		{"1234567890123456789", map[string]interface{}{
+			"emb": []float32{1, 0.5}, "year": json.Number("2020"), "tag": "a"}, false},
+		{"1234567890123456788", map[string]interface{}{"emb": []float32{2, -1}}, false},
+		// documents without ids are numbered in file order
+		{"2", map[string]interface{}{"emb": []float32{3, 3}}, false},
+		{"", nil, true},
+	}
+	for _, tc := range tests {
+		doc, err := rd.Read()
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("read %s, want an error", doc.Id)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if doc.Id != tc.id || !reflect.DeepEqual(doc.Properties, tc.props) {
+			t.Errorf("read %s %v, want %s %v", doc.Id, doc.Properties, tc.id, tc.props)
+		}
+	}
+	if _, err := rd.Read(); err != io.EOF {
+		t.Errorf("read past the end: %v, want EOF", err)
+	}
+}
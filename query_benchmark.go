@@ -5,60 +5,385 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log"
+	"math/rand"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/RedisLabs/redisearch-go/redisearch"
+	"github.com/RedisLabs/rsbench/cluster"
+	"github.com/RedisLabs/rsbench/indexer"
+	"github.com/RedisLabs/rsbench/stats"
+	"github.com/gomodule/redigo/redis"
 )
 
-type QueryBenchmark struct {
-	query        *redisearch.Query
-	client       *redisearch.Client
-	concurrency  int
-	endTime      time.Time
-	startTime    time.Time
-	runDuration  time.Duration
+type queryResult struct {
+	query   *benchQuery
+	node    string
+	latency time.Duration
+	// recall of vector searches with ground truth, -1 for other requests
+	recall float64
+	err    error
+}
+
+// requestStats accumulates the results of a set of requests
+type requestStats struct {
 	numRequests  int
 	totalLatency time.Duration
-	wg           sync.WaitGroup
-	reportch     chan time.Duration
+	hist         *stats.Histogram
+	errors       stats.ErrorCounts
+	recallSum    float64
+	numRecall    int
 }
 
-func NewQueryBenchmark(c *redisearch.Client, q string, concurrency int, runTime time.Duration) *QueryBenchmark {
-	return &QueryBenchmark{
-		query: redisearch.NewQuery(q).
-			SetFlags(redisearch.QueryNoContent|redisearch.QueryVerbatim).
-			Limit(0, 1).
-			SetScorer("DISMAX"),
-		client:      c,
-		concurrency: concurrency,
-		endTime:     time.Now().Add(runTime),
-		reportch:    make(chan time.Duration, concurrency),
-		numRequests: 0,
+func newRequestStats() *requestStats {
+	return &requestStats{
+		hist: stats.NewHistogram(),
 	}
 }
 
-func (b *QueryBenchmark) DumpCSV(out io.Writer) error {
-	cw := csv.NewWriter(out)
+func (s *requestStats) record(r queryResult) {
+	if r.err != nil {
+		s.errors.Add(r.err, 1)
+		return
+	}
+	s.numRequests++
+	s.totalLatency += r.latency
+	s.hist.Record(r.latency)
+	if r.recall >= 0 {
+		s.recallSum += r.recall
+		s.numRecall++
+	}
+}
+
+// recall returns the average recall of the vector searches, or -1 if there were none
+func (s *requestStats) recall() float64 {
+	if s.numRecall == 0 {
+		return -1
+	}
+	return s.recallSum / float64(s.numRecall)
+}
+
+func (s *requestStats) averageLatency() float64 {
+	if s.numRequests == 0 {
+		return 0
+	}
+	return time.Duration(uint64(s.totalLatency)/uint64(s.numRequests)).Seconds() * 1000
+}
+
+func (s *requestStats) errorRate() float64 {
+	errs := s.errors.Total()
+	return stats.Rate(errs, errs+uint64(s.numRequests))
+}
+
+// phaseStats are the stats of the requests that completed during a single phase of the benchmark
+type phaseStats struct {
+	name string
+	// dur is how long the phase was current, from the transitions to and from it
+	dur time.Duration
+	*requestStats
+}
+
+// benchQuery is a single query of the benchmark workload, with the stats of its name
+type benchQuery struct {
+	spec    QuerySpec
+	query   *redisearch.Query
+	aggArgs []interface{}
+	*requestStats
+}
+
+// queryGroup is the queries of the workload that share a name, e.g. the generated queries of a shape, whose
+// stats are reported together
+type queryGroup struct {
+	name    string
+	queries []*benchQuery
+	weight  float64
+	*requestStats
+}
+
+type QueryBenchmark struct {
+	queries     []*benchQuery
+	groups      []*queryGroup
+	weights     []float64
+	cc          *cluster.Client
+	nodes       []*cluster.Node
+	clients     map[string]*redisearch.Client
+	nodeStats   map[string]*requestStats
+	index       string
+	concurrency int
+	endTime     time.Time
+	startTime   time.Time
+	runDuration time.Duration
+	total       *requestStats
+	prefixes    []string
+	vectors     [][]byte
+	truth       [][]string
+	wg          sync.WaitGroup
+	reportch    chan queryResult
+	stopch      chan struct{}
+	stopOnce    sync.Once
+
+	// stats segmented by phase, if phase is set
+	phase      func() string
+	phases     map[string]*phaseStats
+	phaseOrder []*phaseStats
+	curPhase   *phaseStats
+	phaseStart time.Time
+
+	// open loop load generation, if rate > 0
+	rate      float64
+	poisson   bool
+	sched     chan time.Time
+	scheduled int
+	// requests that were due before the end of the run but never sent, because the workers fell behind
+	missed int
+
+	// per interval reporting
+	interval         time.Duration
+	lastSample       time.Time
+	intervalRequests int
+	intervalErrors   int
+	intervalHist     *stats.Histogram
+	ts               *TimeSeriesWriter
+}
+
+// NewQueryBenchmark creates a benchmark of the given query specs. The concurrent connections are spread over
+// the nodes of cc, each of which coordinates the queries it is sent
+func NewQueryBenchmark(cc *cluster.Client, index string, specs []QuerySpec, concurrency int,
+	runTime, interval time.Duration) (*QueryBenchmark, error) {
+	b := &QueryBenchmark{
+		queries:      make([]*benchQuery, 0, len(specs)),
+		weights:      make([]float64, 0, len(specs)),
+		cc:           cc,
+		nodes:        cc.Nodes(),
+		clients:      map[string]*redisearch.Client{},
+		nodeStats:    map[string]*requestStats{},
+		index:        index,
+		concurrency:  concurrency,
+		endTime:      time.Now().Add(runTime),
+		reportch:     make(chan queryResult, concurrency),
+		stopch:       make(chan struct{}),
+		total:        newRequestStats(),
+		interval:     interval,
+		intervalHist: stats.NewHistogram(),
+	}
+	for _, n := range b.nodes {
+		b.clients[n.Addr] = redisearch.NewClient(n.Addr, index)
+	}
+	// weights holds the cumulative weights of the queries, for weighted random selection
+	var sum float64
+	groups := map[string]*queryGroup{}
+	for _, spec := range specs {
+		g, ok := groups[spec.Name]
+		if !ok {
+			g = &queryGroup{name: spec.Name, requestStats: newRequestStats()}
+			groups[spec.Name] = g
+			b.groups = append(b.groups, g)
+		}
+		q := &benchQuery{
+			spec:         spec,
+			query:        spec.Build(),
+			requestStats: g.requestStats,
+		}
+		g.queries = append(g.queries, q)
+		g.weight += spec.Weight
+		if spec.IsAggregate() {
+			var err error
+			if q.aggArgs, err = spec.AggregateArgs(index); err != nil {
+				return nil, err
+			}
+		}
+		b.queries = append(b.queries, q)
+		sum += spec.Weight
+		b.weights = append(b.weights, sum)
+	}
+	return b, nil
+}
+
+// SetRate switches the benchmark to open loop: requests are scheduled at a fixed arrival rate (in requests
+// per second) regardless of how fast they complete, with constant or exponentially distributed (Poisson)
+// inter-arrival times. Latency is measured from the scheduled send time, so that queueing delays under overload
+// are accounted for
+func (b *QueryBenchmark) SetRate(rate float64, poisson bool) {
+	b.rate = rate
+	b.poisson = poisson
+}
+
+// SetPhases segments the stats by the phase that phase returns at the time each request completes
+func (b *QueryBenchmark) SetPhases(phase func() string) {
+	b.phase = phase
+	b.phases = map[string]*phaseStats{}
+}
+
+// Stop ends the benchmark before its run time is over
+func (b *QueryBenchmark) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.stopch)
+	})
+}
+
+func (b *QueryBenchmark) running() bool {
+	select {
+	case <-b.stopch:
+		return false
+	default:
+		return time.Now().Before(b.endTime)
+	}
+}
+
+// SetPrefixes sets the terms that suggestion requests without a fixed prefix sample their prefixes from
+func (b *QueryBenchmark) SetPrefixes(terms []string) {
+	b.prefixes = terms
+}
+
+// SetVectors sets the query vectors that vector searches pick from, and optionally the ids of their exact nearest
+// neighbors, nearest first, to compute the recall of KNN searches against
+func (b *QueryBenchmark) SetVectors(vecs [][]float32, truth [][]string) {
+	b.vectors = make([][]byte, len(vecs))
+	for i, vec := range vecs {
+		b.vectors[i] = indexer.VectorBlob(vec)
+	}
+	b.truth = truth
+}
+
+// SetTimeSeries makes the benchmark write a sample to ts at the end of every reporting interval
+func (b *QueryBenchmark) SetTimeSeries(ts *TimeSeriesWriter) {
+	b.ts = ts
+}
+
+// rps returns the requests per second of s over dur, or 0 if dur is empty
+func (s *requestStats) rps(dur time.Duration) float64 {
+	if dur <= 0 {
+		return 0
+	}
+	return float64(s.numRequests) / dur.Seconds()
+}
+
+func (b *QueryBenchmark) csvRow(name string, s *requestStats, dur time.Duration) []string {
 	vals := []string{
-		b.query.Raw,
+		name,
 		strconv.FormatInt(int64(b.concurrency), 10),
-		strconv.FormatFloat(b.RequestsPerSecond(), 'f', 2, 64),
-		strconv.FormatFloat(b.AverageLatency(), 'f', 2, 64),
+		strconv.FormatFloat(s.rps(dur), 'f', 2, 64),
+		strconv.FormatFloat(s.averageLatency(), 'f', 2, 64),
+		strconv.FormatUint(s.errors.Total(), 10),
+		strconv.FormatFloat(s.errorRate(), 'f', 4, 64),
+	}
+	vals = append(vals, s.hist.Summary().CSV()...)
+	if b.truth != nil {
+		// the recall column is only written with ground truth, and empty for rows without vector searches
+		recall := ""
+		if r := s.recall(); r >= 0 {
+			recall = strconv.FormatFloat(r, 'f', 4, 64)
+		}
+		vals = append(vals, recall)
+	}
+	return vals
+}
+
+// DumpCSV writes one row per query name, followed by a row for the whole workload named "*" if
+// more than one name was benchmarked, and a row per phase named "phase:<name>" if phases are set
+func (b *QueryBenchmark) DumpCSV(out io.Writer) error {
+	cw := csv.NewWriter(out)
+	for _, g := range b.groups {
+		if e := cw.Write(b.csvRow(g.name, g.requestStats, b.runDuration)); e != nil {
+			return e
+		}
+	}
+	if len(b.groups) > 1 {
+		if e := cw.Write(b.csvRow("*", b.total, b.runDuration)); e != nil {
+			return e
+		}
 	}
-	if e := cw.Write(vals); e != nil {
-		return e
+	for _, p := range b.phaseOrder {
+		if e := cw.Write(b.csvRow("phase:"+p.name, p.requestStats, p.dur)); e != nil {
+			return e
+		}
+	}
+	if len(b.nodeStats) > 1 {
+		for _, addr := range b.nodeAddrs() {
+			if e := cw.Write(b.csvRow("node:"+addr, b.nodeStats[addr], b.runDuration)); e != nil {
+				return e
+			}
+		}
 	}
 	cw.Flush()
-	return nil
+	return cw.Error()
 }
-func (b *QueryBenchmark) DumpJson(out io.Writer) error {
+
+func (b *QueryBenchmark) jsonValues(s *requestStats, dur time.Duration) map[string]interface{} {
 	values := map[string]interface{}{
-		"query":       b.query.Raw,
-		"concurrency": b.concurrency,
-		"rps":         b.RequestsPerSecond(),
-		"latency":     b.AverageLatency(),
+		"requests":    s.numRequests,
+		"rps":         s.rps(dur),
+		"latency":     s.averageLatency(),
+		"percentiles": s.hist.Summary(),
+		"errors":      s.errors.Total(),
+		"error_rate":  s.errorRate(),
+		"error_types": s.errors.Map(),
+	}
+	if r := s.recall(); r >= 0 {
+		values["recall"] = r
+	}
+	return values
+}
+
+func (b *QueryBenchmark) DumpJson(out io.Writer) error {
+	values := b.jsonValues(b.total, b.runDuration)
+	values["concurrency"] = b.concurrency
+	if b.rate > 0 {
+		values["target_rate"] = b.rate
+		values["scheduled"] = b.scheduled
+		values["missed"] = b.missed
+	}
+	if len(b.queries) == 1 {
+		values["query"] = b.queries[0].query.Raw
+	}
+	queries := make([]map[string]interface{}, 0, len(b.groups))
+	for _, g := range b.groups {
+		qv := b.jsonValues(g.requestStats, b.runDuration)
+		qv["name"] = g.name
+		qv["weight"] = g.weight
+		if len(g.queries) > 1 {
+			// the options of the queries that share a name may differ, so only their number is reported
+			qv["count"] = len(g.queries)
+			queries = append(queries, qv)
+			continue
+		}
+		q := g.queries[0]
+		qv["query"] = q.query.Raw
+		if q.spec.IsAggregate() {
+			qv["aggregate"] = q.spec.Aggregate
+		}
+		if q.spec.IsSuggest() {
+			qv["suggest"] = q.spec.Suggest
+		}
+		if q.spec.KNN > 0 {
+			qv["knn"] = q.spec.KNN
+		} else if q.spec.VectorRange > 0 {
+			qv["vector_range"] = q.spec.VectorRange
+		}
+		queries = append(queries, qv)
+	}
+	values["queries"] = queries
+	if b.phase != nil {
+		phases := make([]map[string]interface{}, 0, len(b.phaseOrder))
+		for _, p := range b.phaseOrder {
+			pv := b.jsonValues(p.requestStats, p.dur)
+			pv["phase"] = p.name
+			pv["duration"] = p.dur.Seconds()
+			phases = append(phases, pv)
+		}
+		values["phases"] = phases
+	}
+	if len(b.nodeStats) > 1 {
+		nodes := make([]map[string]interface{}, 0, len(b.nodeStats))
+		for _, addr := range b.nodeAddrs() {
+			nv := b.jsonValues(b.nodeStats[addr], b.runDuration)
+			nv["node"] = addr
+			nodes = append(nodes, nv)
+		}
+		values["nodes"] = nodes
 	}
 
 	enc := json.NewEncoder(out)
@@ -66,13 +391,127 @@ func (b *QueryBenchmark) DumpJson(out io.Writer) error {
 	return enc.Encode(values)
 }
 
-func (b *QueryBenchmark) loop() {
-	tm := time.Now()
-	for tm.Before(b.endTime) {
-		_, _, err := b.client.Search(b.query)
-		if err == nil {
-			b.reportch <- time.Since(tm)
+// nodeAddrs returns the addresses of the nodes that requests were sent to, sorted
+func (b *QueryBenchmark) nodeAddrs() []string {
+	ret := make([]string, 0, len(b.nodeStats))
+	for addr := range b.nodeStats {
+		ret = append(ret, addr)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// pick selects a query at random according to the workload weights
+func (b *QueryBenchmark) pick(rng *rand.Rand) *benchQuery {
+	if len(b.queries) == 1 {
+		return b.queries[0]
+	}
+	n := rng.Float64() * b.weights[len(b.weights)-1]
+	return b.queries[sort.SearchFloat64s(b.weights, n)]
+}
+
+// aggregate runs an FT.AGGREGATE request. With a cursor, all the cursor's results are read before it returns
+func (b *QueryBenchmark) aggregate(q *benchQuery, node *cluster.Node) error {
+	conn := node.Get()
+	defer conn.Close()
+
+	rep, err := redis.Values(conn.Do("FT.AGGREGATE", q.aggArgs...))
+	if err != nil || !q.spec.WithCursor {
+		return err
+	}
+	// with a cursor, the reply is the first batch of results followed by the cursor id
+	for {
+		if len(rep) != 2 {
+			return fmt.Errorf("unexpected cursor reply of length %d", len(rep))
+		}
+		cid, err := redis.Int64(rep[1], nil)
+		if err != nil || cid == 0 {
+			return err
 		}
+		if rep, err = redis.Values(conn.Do("FT.CURSOR", "READ", b.index, cid)); err != nil {
+			// free the cursor rather than leave it to its idle timeout, which may fail too if the connection broke
+			conn.Do("FT.CURSOR", "DEL", b.index, cid)
+			return err
+		}
+	}
+}
+
+// minPrefixLen is the shortest sampled suggestion prefix, as a search-as-you-type box would send
+const minPrefixLen = 2
+
+// randomPrefix returns a prefix of a random sampled term
+func (b *QueryBenchmark) randomPrefix(rng *rand.Rand) string {
+	term := []rune(b.prefixes[rng.Intn(len(b.prefixes))])
+	if len(term) <= minPrefixLen {
+		return string(term)
+	}
+	return string(term[:minPrefixLen+rng.Intn(len(term)-minPrefixLen+1)])
+}
+
+// suggest runs an FT.SUGGET request on the node that owns the dictionary key, and returns the node that served
+// it, after any redirect
+func (b *QueryBenchmark) suggest(q *benchQuery, rng *rand.Rand) (string, error) {
+	prefix := q.spec.Query
+	if prefix == "" {
+		prefix = b.randomPrefix(rng)
+	}
+	_, node, err := b.cc.DoNode(b.cc.NodeForKey(q.spec.Suggest), q.spec.Suggest, "FT.SUGGET",
+		q.spec.SuggestArgs(prefix)...)
+	return node.Addr, err
+}
+
+// vectorSearch runs a vector search with a random query vector. It returns the recall of KNN searches if the
+// vector's nearest neighbors are known, or -1
+func (b *QueryBenchmark) vectorSearch(q *benchQuery, rng *rand.Rand, node *cluster.Node) (float64, error) {
+	i := rng.Intn(len(b.vectors))
+	conn := node.Get()
+	defer conn.Close()
+	rep, err := redis.Values(conn.Do("FT.SEARCH", q.spec.VectorArgs(b.index, b.vectors[i])...))
+	if err != nil {
+		return -1, err
+	}
+	if q.spec.KNN == 0 || b.truth == nil {
+		return -1, nil
+	}
+	// the reply is the total number of results followed by the ids, each followed by its fields unless
+	// NOCONTENT is set
+	step := 2
+	if q.spec.NoContent {
+		step = 1
+	}
+	ids := []string{}
+	for j := 1; j < len(rep); j += step {
+		id, _ := redis.String(rep[j], nil)
+		ids = append(ids, id)
+	}
+	return recall(ids, b.truth[i], q.spec.KNN), nil
+}
+
+// do runs a single request, and returns the address of the node it was sent to and the request's recall, or -1.
+// Searches and aggregations are sent to the worker's node
+func (b *QueryBenchmark) do(q *benchQuery, rng *rand.Rand, node *cluster.Node) (string, float64, error) {
+	if q.spec.IsAggregate() {
+		return node.Addr, -1, b.aggregate(q, node)
+	}
+	if q.spec.IsSuggest() {
+		addr, err := b.suggest(q, rng)
+		return addr, -1, err
+	}
+	if q.spec.IsVector() {
+		r, err := b.vectorSearch(q, rng, node)
+		return node.Addr, r, err
+	}
+	_, _, err := b.clients[node.Addr].Search(q.query)
+	return node.Addr, -1, err
+}
+
+func (b *QueryBenchmark) loop(seed int64, node *cluster.Node) {
+	rng := rand.New(rand.NewSource(seed))
+	tm := time.Now()
+	for b.running() {
+		q := b.pick(rng)
+		addr, r, err := b.do(q, rng, node)
+		b.reportch <- queryResult{query: q, node: addr, latency: time.Since(tm), recall: r, err: err}
 		tm = time.Now()
 
 	}
@@ -80,43 +519,203 @@ func (b *QueryBenchmark) loop() {
 
 }
 
+// nextSend returns the intended send time of the open loop request that follows the one intended at t
+func (b *QueryBenchmark) nextSend(t time.Time, rng *rand.Rand) time.Time {
+	gap := 1 / b.rate
+	if b.poisson {
+		gap = rng.ExpFloat64() / b.rate
+	}
+	return t.Add(time.Duration(gap * float64(time.Second)))
+}
+
+// schedule sends the intended send times of open loop requests to the workers until the end of the run
+func (b *QueryBenchmark) schedule(seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	next := time.Now()
+	// if the workers fall behind, the schedule is kept and late requests are sent as soon as a worker frees up
+	for next.Before(b.endTime) && b.running() {
+		if d := time.Until(next); d > 0 {
+			time.Sleep(d)
+		}
+		b.sched <- next
+		b.scheduled++
+		next = b.nextSend(next, rng)
+	}
+	// the backlog of requests that were due when the run ended is counted as missed
+	end := time.Now()
+	if b.endTime.Before(end) {
+		end = b.endTime
+	}
+	for ; next.Before(end); next = b.nextSend(next, rng) {
+		b.missed++
+	}
+	close(b.sched)
+}
+
+func (b *QueryBenchmark) openLoop(seed int64, node *cluster.Node) {
+	rng := rand.New(rand.NewSource(seed))
+	for intended := range b.sched {
+		q := b.pick(rng)
+		addr, r, err := b.do(q, rng, node)
+		b.reportch <- queryResult{query: q, node: addr, latency: time.Since(intended), recall: r, err: err}
+	}
+	b.wg.Done()
+}
+
 func (b *QueryBenchmark) RequestsPerSecond() float64 {
-	return float64(b.numRequests) / time.Since(b.startTime).Seconds()
+	return float64(b.total.numRequests) / time.Since(b.startTime).Seconds()
 }
 
 func (b *QueryBenchmark) AverageLatency() float64 {
-	return time.Duration(uint64(b.totalLatency)/uint64(b.numRequests)).Seconds() * 1000
+	return b.total.averageLatency()
+}
+
+// LatencyPercentile returns the latency of successful requests at the given percentile, in milliseconds
+func (b *QueryBenchmark) LatencyPercentile(p float64) float64 {
+	return b.total.hist.Percentile(p)
+}
+
+// ErrorRate returns the fraction of requests that failed
+func (b *QueryBenchmark) ErrorRate() float64 {
+	return b.total.errorRate()
+}
+
+// updatePhase switches to the current phase if it changed since the last call, ending the span of the previous
+// phase at now, and returns the current phase
+func (b *QueryBenchmark) updatePhase(now time.Time) *phaseStats {
+	name := b.phase()
+	if b.curPhase != nil {
+		if b.curPhase.name == name {
+			return b.curPhase
+		}
+		b.curPhase.dur += now.Sub(b.phaseStart)
+	}
+	p, ok := b.phases[name]
+	if !ok {
+		p = &phaseStats{name: name, requestStats: newRequestStats()}
+		b.phases[name] = p
+		b.phaseOrder = append(b.phaseOrder, p)
+	}
+	b.curPhase = p
+	b.phaseStart = now
+	return p
+}
+
+func (b *QueryBenchmark) record(r queryResult) {
+	b.total.record(r)
+	r.query.record(r)
+	ns, ok := b.nodeStats[r.node]
+	if !ok {
+		ns = newRequestStats()
+		b.nodeStats[r.node] = ns
+	}
+	ns.record(r)
+	if b.phase != nil {
+		b.updatePhase(time.Now()).record(r)
+	}
+	if r.err != nil {
+		b.intervalErrors++
+		return
+	}
+	b.intervalRequests++
+	b.intervalHist.Record(r.latency)
+}
+
+// sample prints the progress line and writes the time series sample for the interval that just ended
+func (b *QueryBenchmark) sample() {
+	now := time.Now()
+	if errs := b.total.errors.Total(); b.total.numRequests > 0 || errs > 0 {
+		s := b.total.hist.Summary()
+		fmt.Printf("%d requests in %v, rate: %.02fr/s, Avg. latency: %.02fms, p50: %.02fms, p99: %.02fms, max: %.02fms, errors: %d (%.02f%%) %s\n",
+			b.total.numRequests, now.Sub(b.startTime),
+			b.RequestsPerSecond(),
+			b.AverageLatency(), s.P50, s.P99, s.Max,
+			errs, b.ErrorRate()*100, b.total.errors.String())
+		if r := b.total.recall(); r >= 0 {
+			fmt.Printf("Recall@k: %.04f\n", r)
+		}
+	}
+
+	if b.ts != nil {
+		err := b.ts.Write(IntervalSample{
+			Elapsed:  now.Sub(b.startTime).Seconds(),
+			Requests: b.intervalRequests,
+			Errors:   b.intervalErrors,
+			RPS:      float64(b.intervalRequests) / now.Sub(b.lastSample).Seconds(),
+			Latency:  b.intervalHist.Summary(),
+		})
+		if err != nil {
+			log.Printf("Error writing time series sample: %s", err)
+		}
+	}
+
+	b.intervalRequests = 0
+	b.intervalErrors = 0
+	b.intervalHist.Reset()
+	b.lastSample = now
 }
 
 func (b *QueryBenchmark) Run() error {
+	for _, q := range b.queries {
+		if q.spec.IsSuggest() && q.spec.Query == "" && len(b.prefixes) == 0 {
+			return fmt.Errorf("%s: no fixed prefix and no terms to sample prefixes from", q.spec.Name)
+		}
+		if q.spec.IsVector() && len(b.vectors) == 0 {
+			return fmt.Errorf("%s: no query vectors", q.spec.Name)
+		}
+	}
+	seed := time.Now().UnixNano()
+	if b.rate > 0 {
+		// allow up to a second of backlog before the scheduler blocks
+		backlog := int(b.rate)
+		if backlog < b.concurrency {
+			backlog = b.concurrency
+		}
+		b.sched = make(chan time.Time, backlog)
+		go b.schedule(seed - 1)
+	}
 	for i := 0; i < b.concurrency; i++ {
 		b.wg.Add(1)
-		go b.loop()
+		node := b.nodes[i%len(b.nodes)]
+		if b.rate > 0 {
+			go b.openLoop(seed+int64(i), node)
+		} else {
+			go b.loop(seed+int64(i), node)
+		}
 	}
 	go func() {
 		b.wg.Wait()
 		close(b.reportch)
 	}()
 	b.startTime = time.Now()
-	lastSample := time.Now()
+	b.lastSample = b.startTime
+	if b.phase != nil {
+		b.updatePhase(b.startTime)
+	}
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
 	for {
-		if latency, ok := <-b.reportch; ok {
-			b.numRequests++
-			b.totalLatency += latency
-			if time.Since(lastSample) > time.Second {
-				fmt.Printf("%d requests in %v, rate: %.02fr/s, Avg. latency: %.02fms\n", b.numRequests, time.Since(b.startTime),
-					b.RequestsPerSecond(),
-					b.AverageLatency())
-				lastSample = time.Now()
+		select {
+		case r, ok := <-b.reportch:
+			if !ok {
+				end := time.Now()
+				b.runDuration = end.Sub(b.startTime)
+				if b.phase != nil {
+					// the last phase lasts until the end of the run
+					b.updatePhase(end)
+					b.curPhase.dur += end.Sub(b.phaseStart)
+				}
+				// flush the last, possibly partial, interval
+				b.sample()
+				return nil
 			}
-		} else {
-			b.runDuration = time.Since(b.startTime)
-			break
+			b.record(r)
+		case <-ticker.C:
+			// phase transitions are also caught between completions
+			if b.phase != nil {
+				b.updatePhase(time.Now())
+			}
+			b.sample()
 		}
 	}
-
-	// log.Printf("%d requests for %s in %v, rate: %.02fr/s, Avg. Latency %.02fms", b.numRequests, b.query.Raw, b.runDuration,
-	// 	b.RequestsPerSecond(), b.AverageLatency())
-
-	return nil
 }
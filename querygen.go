@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/RedisLabs/rsbench/indexer"
+)
+
+// QueryShapes are the shapes of queries GenerateQueries can generate
+var QueryShapes = []string{"high", "mid", "low", "and", "or", "phrase", "prefix", "fuzzy", "numeric", "tag"}
+
+// frequency bands of terms, by document frequency rank: the top 1% of the sampled terms are high frequency,
+// the next 9% mid frequency, and the rest low frequency
+const (
+	highBand = 0.01
+	midBand  = 0.1
+)
+
+// GeneratedQuery is a workload line of a generated query
+type GeneratedQuery struct {
+	Name  string `json:"name"`
+	Query string `json:"query"`
+}
+
+type queryGenerator struct {
+	rng    *rand.Rand
+	terms  []indexer.TermFreq
+	common []indexer.TermFreq
+}
+
+// bands returns the ends of the high and mid frequency bands in the terms
+func (g *queryGenerator) bands() (high, mid int) {
+	high = int(float64(len(g.terms)) * highBand)
+	if high == 0 {
+		high = 1
+	}
+	mid = int(float64(len(g.terms)) * midBand)
+	if mid < high {
+		mid = high
+	}
+	return high, mid
+}
+
+// band returns the terms of a frequency band
+func (g *queryGenerator) band(shape string) []indexer.TermFreq {
+	high, mid := g.bands()
+	switch shape {
+	case "high":
+		return g.terms[:high]
+	case "mid":
+		return g.terms[high:mid]
+	}
+	return g.terms[mid:]
+}
+
+func (g *queryGenerator) term(terms []indexer.TermFreq) string {
+	return terms[g.rng.Intn(len(terms))].Term
+}
+
+// typo replaces a random character of a term
+func (g *queryGenerator) typo(term string) string {
+	r := []rune(term)
+	r[g.rng.Intn(len(r))] = rune('a' + g.rng.Intn(26))
+	return string(r)
+}
+
+// escapeTag escapes the punctuation and spaces of a tag value
+func escapeTag(v string) string {
+	var sb strings.Builder
+	for _, r := range v {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// weighted picks a value at random, weighted by its frequency
+func (g *queryGenerator) weighted(values []indexer.TermFreq) string {
+	total := 0
+	for _, v := range values {
+		total += v.Docs
+	}
+	n := g.rng.Intn(total)
+	for _, v := range values {
+		if n -= v.Docs; n < 0 {
+			return v.Term
+		}
+	}
+	return values[len(values)-1].Term
+}
+
+func formatNum(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// generate returns a query of the given shape, or false if the model has nothing to make it from
+func (g *queryGenerator) generate(shape string, fields []string, ranges map[string][2]float64,
+	tags map[string][]indexer.TermFreq, phrases []string) (string, bool) {
+	switch shape {
+	case "high", "mid", "low":
+		terms := g.band(shape)
+		if len(terms) == 0 {
+			return "", false
+		}
+		return g.term(terms), true
+	case "and", "or":
+		if len(g.common) < 2 {
+			return "", false
+		}
+		n := 2 + g.rng.Intn(2)
+		terms := make([]string, n)
+		for i := range terms {
+			terms[i] = g.term(g.common)
+		}
+		if shape == "and" {
+			return strings.Join(terms, " "), true
+		}
+		return "(" + strings.Join(terms, "|") + ")", true
+	case "phrase":
+		if len(phrases) == 0 {
+			return "", false
+		}
+		return `"` + phrases[g.rng.Intn(len(phrases))] + `"`, true
+	case "prefix", "fuzzy":
+		candidates := []string{}
+		for _, t := range g.common {
+			if len([]rune(t.Term)) >= 4 {
+				candidates = append(candidates, t.Term)
+			}
+		}
+		if len(candidates) == 0 {
+			return "", false
+		}
+		term := []rune(candidates[g.rng.Intn(len(candidates))])
+		if shape == "prefix" {
+			return string(term[:3+g.rng.Intn(len(term)-3)]) + "*", true
+		}
+		return "%" + g.typo(string(term)) + "%", true
+	case "numeric":
+		if len(fields) == 0 {
+			return "", false
+		}
+		field := fields[g.rng.Intn(len(fields))]
+		r := ranges[field]
+		// a range of 0.1% to 10% of the observed range
+		width := (r[1] - r[0]) * (0.001 + g.rng.Float64()*0.099)
+		min := r[0] + g.rng.Float64()*(r[1]-r[0]-width)
+		return fmt.Sprintf("@%s:[%s %s]", field, formatNum(min), formatNum(min+width)), true
+	case "tag":
+		if len(tags) == 0 {
+			return "", false
+		}
+		names := make([]string, 0, len(tags))
+		for k := range tags {
+			names = append(names, k)
+		}
+		// map order is random, so the field is picked from the sorted names for reproducibility
+		sort.Strings(names)
+		field := names[g.rng.Intn(len(names))]
+		return fmt.Sprintf("@%s:{%s}", field, escapeTag(g.weighted(tags[field]))), true
+	}
+	return "", false
+}
+
+// GenerateQueries generates n queries of every shape from a corpus model. Shapes the model has nothing to make
+// queries from, e.g. numeric ranges without numeric fields, are skipped
+func GenerateQueries(m *indexer.CorpusModel, shapes []string, n int, seed int64) ([]GeneratedQuery, error) {
+	g := &queryGenerator{
+		rng:   rand.New(rand.NewSource(seed)),
+		terms: m.Terms(),
+	}
+	if len(g.terms) == 0 {
+		return nil, fmt.Errorf("no terms were sampled")
+	}
+	// multi-term, prefix and fuzzy queries are made of high and mid frequency terms
+	_, mid := g.bands()
+	g.common = g.terms[:mid]
+	ranges := m.NumericRanges()
+	fields := make([]string, 0, len(ranges))
+	for k := range ranges {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	tags := m.TagValues()
+	phrases := m.Phrases()
+
+	ret := []GeneratedQuery{}
+	for _, shape := range shapes {
+		valid := false
+		for _, s := range QueryShapes {
+			valid = valid || s == shape
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid query shape %q, expected one of %s", shape, strings.Join(QueryShapes, ","))
+		}
+		for i := 0; i < n; i++ {
+			q, ok := g.generate(shape, fields, ranges, tags, phrases)
+			if !ok {
+				log.Printf("No %s queries can be generated from the sampled documents", shape)
+				break
+			}
+			// queries are named by shape, so that the benchmark reports the stats of every shape
+			ret = append(ret, GeneratedQuery{Name: shape, Query: q})
+		}
+	}
+	return ret, nil
+}
+
+// WriteWorkload writes generated queries as a JSON lines workload
+func WriteWorkload(out io.Writer, queries []GeneratedQuery, comment string) error {
+	if _, err := fmt.Fprintf(out, "# %s\n", comment); err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for _, q := range queries {
+		if err := enc.Encode(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteWorkloadFile writes generated queries to a workload file
+func WriteWorkloadFile(path string, queries []GeneratedQuery, comment string) error {
+	fp, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := WriteWorkload(fp, queries, comment); err != nil {
+		fp.Close()
+		return err
+	}
+	return fp.Close()
+}
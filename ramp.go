@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"strconv"
+
+	"github.com/RedisLabs/rsbench/stats"
+)
+
+// RampOptions configures a throughput ramp: the request rate is increased by Step every step, starting at Start,
+// until the latency at Percentile exceeds SLO, the error or missed rate exceeds MaxErrorRate, or the rate passes Max
+type RampOptions struct {
+	Start        float64
+	Step         float64
+	Max          float64
+	SLO          float64 // milliseconds
+	Percentile   float64
+	MaxErrorRate float64
+}
+
+// RampStep holds the results of a single ramp step
+type RampStep struct {
+	Rate      float64       `json:"rate"`
+	RPS       float64       `json:"rps"`
+	Latency   float64       `json:"latency"`
+	Summary   stats.Summary `json:"percentiles"`
+	ErrorRate float64       `json:"error_rate"`
+	// Missed are the requests that were due but never sent, because the workers fell behind
+	Missed int  `json:"missed"`
+	Passed bool `json:"passed"`
+}
+
+type RampReport struct {
+	Options RampOptions `json:"-"`
+	Steps   []RampStep  `json:"steps"`
+	// MaxRate is the highest rate that met the SLO, or 0 if none did
+	MaxRate float64 `json:"max_rate"`
+}
+
+// RunRamp runs open loop benchmarks at increasing rates. newBench is called to create the benchmark of every step
+func RunRamp(newBench func(rate float64) (*QueryBenchmark, error), opts RampOptions) (*RampReport, error) {
+	report := &RampReport{Options: opts}
+	for rate := opts.Start; rate <= opts.Max; rate += opts.Step {
+		b, err := newBench(rate)
+		if err != nil {
+			return nil, err
+		}
+		if err := b.Run(); err != nil {
+			return nil, err
+		}
+		step := RampStep{
+			Rate:      rate,
+			RPS:       float64(b.total.numRequests) / b.runDuration.Seconds(),
+			Latency:   b.LatencyPercentile(opts.Percentile),
+			Summary:   b.total.hist.Summary(),
+			ErrorRate: b.ErrorRate(),
+			Missed:    b.missed,
+		}
+		missedRate := stats.Rate(uint64(b.missed), uint64(b.scheduled+b.missed))
+		step.Passed = b.total.numRequests > 0 && step.Latency <= opts.SLO && step.ErrorRate <= opts.MaxErrorRate &&
+			missedRate <= opts.MaxErrorRate
+		report.Steps = append(report.Steps, step)
+		log.Printf("Ramp step at %.02fr/s: achieved %.02fr/s, p%g latency %.02fms (SLO %.02fms), error rate %.02f%%, missed %d, passed: %v",
+			rate, step.RPS, opts.Percentile, step.Latency, opts.SLO, step.ErrorRate*100, step.Missed, step.Passed)
+		if !step.Passed {
+			break
+		}
+		report.MaxRate = rate
+	}
+	return report, nil
+}
+
+func (r *RampReport) DumpJson(out io.Writer) error {
+	values := map[string]interface{}{
+		"max_rate":       r.MaxRate,
+		"slo":            r.Options.SLO,
+		"percentile":     r.Options.Percentile,
+		"max_error_rate": r.Options.MaxErrorRate,
+		"steps":          r.Steps,
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "\t")
+	return enc.Encode(values)
+}
+
+// DumpCSV writes a row per ramp step
+func (r *RampReport) DumpCSV(out io.Writer) error {
+	cw := csv.NewWriter(out)
+	for _, s := range r.Steps {
+		vals := []string{
+			strconv.FormatFloat(s.Rate, 'f', 2, 64),
+			strconv.FormatFloat(s.RPS, 'f', 2, 64),
+			strconv.FormatFloat(s.Latency, 'f', 2, 64),
+			strconv.FormatFloat(s.ErrorRate, 'f', 4, 64),
+			strconv.Itoa(s.Missed),
+			strconv.FormatBool(s.Passed),
+		}
+		if e := cw.Write(append(vals, s.Summary.CSV()...)); e != nil {
+			return e
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,124 @@
+package stats
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrorClass is a coarse classification of the errors returned by redis
+type ErrorClass int
+
+const (
+	ErrTimeout ErrorClass = iota
+	ErrConnRefused
+	ErrOOM
+	ErrIndexNotFound
+	ErrSyntax
+	ErrRedis
+	ErrOther
+	numErrorClasses
+)
+
+var errorClassNames = [numErrorClasses]string{
+	"timeout",
+	"connection_refused",
+	"oom",
+	"index_not_found",
+	"syntax",
+	"redis",
+	"other",
+}
+
+func (c ErrorClass) String() string {
+	return errorClassNames[c]
+}
+
+// ClassifyError returns the class of an error returned by redis or the network
+func ClassifyError(err error) ErrorClass {
+	msg := err.Error()
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ErrTimeout
+	}
+	switch {
+	case strings.Contains(msg, "i/o timeout"), strings.Contains(msg, "Timeout limit"):
+		return ErrTimeout
+	case strings.Contains(msg, "connection refused"):
+		return ErrConnRefused
+	case strings.HasPrefix(msg, "OOM"):
+		return ErrOOM
+	case strings.Contains(strings.ToLower(msg), "unknown index name"), strings.Contains(msg, "no such index"):
+		return ErrIndexNotFound
+	case strings.Contains(strings.ToLower(msg), "syntax error"):
+		return ErrSyntax
+	}
+	if _, ok := err.(redis.Error); ok {
+		return ErrRedis
+	}
+	return ErrOther
+}
+
+// ErrorCounts counts errors by class. It is safe for concurrent use
+type ErrorCounts struct {
+	counts [numErrorClasses]uint64
+}
+
+// Add classifies err and counts it n times, returning its class
+func (e *ErrorCounts) Add(err error, n int) ErrorClass {
+	c := ClassifyError(err)
+	atomic.AddUint64(&e.counts[c], uint64(n))
+	return c
+}
+
+func (e *ErrorCounts) Total() uint64 {
+	var ret uint64
+	for i := range e.counts {
+		ret += atomic.LoadUint64(&e.counts[i])
+	}
+	return ret
+}
+
+// Map returns the non zero counts keyed by class name
+func (e *ErrorCounts) Map() map[string]uint64 {
+	ret := map[string]uint64{}
+	for i := range e.counts {
+		if n := atomic.LoadUint64(&e.counts[i]); n > 0 {
+			ret[ErrorClass(i).String()] = n
+		}
+	}
+	return ret
+}
+
+// AddCounts adds counts keyed by class name, as returned by Map. Unknown class names are counted as other errors
+func (e *ErrorCounts) AddCounts(m map[string]uint64) {
+	for name, n := range m {
+		c := ErrOther
+		for i, cn := range errorClassNames {
+			if cn == name {
+				c = ErrorClass(i)
+			}
+		}
+		atomic.AddUint64(&e.counts[c], n)
+	}
+}
+
+func (e *ErrorCounts) String() string {
+	parts := []string{}
+	for i := range e.counts {
+		if n := atomic.LoadUint64(&e.counts[i]); n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", ErrorClass(i), n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Rate returns the fraction of failed operations out of total attempted operations
+func Rate(errors, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(errors) / float64(total)
+}
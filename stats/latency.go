@@ -0,0 +1,101 @@
+package stats
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+const (
+	// latencies are recorded in microseconds, from 1us up to one minute
+	minLatency  = 1
+	maxLatency  = int64(time.Minute / time.Microsecond)
+	significant = 3
+)
+
+// Histogram is a high dynamic range latency histogram. It is not safe for concurrent use
+type Histogram struct {
+	h *hdrhistogram.Histogram
+}
+
+func NewHistogram() *Histogram {
+	return &Histogram{
+		h: hdrhistogram.New(minLatency, maxLatency, significant),
+	}
+}
+
+// Record adds a single latency sample. Samples above the histogram's range are clamped to its maximum
+func (h *Histogram) Record(d time.Duration) {
+	v := int64(d / time.Microsecond)
+	if v < minLatency {
+		v = minLatency
+	} else if v > maxLatency {
+		v = maxLatency
+	}
+	h.h.RecordValue(v)
+}
+
+// Merge adds all the samples of other into h
+func (h *Histogram) Merge(other *Histogram) {
+	h.h.Merge(other.h)
+}
+
+func (h *Histogram) Reset() {
+	h.h.Reset()
+}
+
+func (h *Histogram) Count() int64 {
+	return h.h.TotalCount()
+}
+
+// Percentile returns the latency at the given percentile (0-100), in milliseconds
+func (h *Histogram) Percentile(p float64) float64 {
+	return usecToMs(float64(h.h.ValueAtQuantile(p)))
+}
+
+// Summary holds the latency distribution of a histogram, in milliseconds
+type Summary struct {
+	Min    float64 `json:"min"`
+	Avg    float64 `json:"avg"`
+	StdDev float64 `json:"stddev"`
+	P50    float64 `json:"p50"`
+	P90    float64 `json:"p90"`
+	P95    float64 `json:"p95"`
+	P99    float64 `json:"p99"`
+	P999   float64 `json:"p99.9"`
+	Max    float64 `json:"max"`
+}
+
+func usecToMs(v float64) float64 {
+	return v / 1000
+}
+
+func (h *Histogram) Summary() Summary {
+	if h.h.TotalCount() == 0 {
+		return Summary{}
+	}
+	return Summary{
+		Min:    usecToMs(float64(h.h.Min())),
+		Avg:    usecToMs(h.h.Mean()),
+		StdDev: usecToMs(h.h.StdDev()),
+		P50:    usecToMs(float64(h.h.ValueAtQuantile(50))),
+		P90:    usecToMs(float64(h.h.ValueAtQuantile(90))),
+		P95:    usecToMs(float64(h.h.ValueAtQuantile(95))),
+		P99:    usecToMs(float64(h.h.ValueAtQuantile(99))),
+		P999:   usecToMs(float64(h.h.ValueAtQuantile(99.9))),
+		Max:    usecToMs(float64(h.h.Max())),
+	}
+}
+
+// SummaryCSVHeader names the columns returned by Summary.CSV
+var SummaryCSVHeader = []string{"Min", "Avg", "StdDev", "p50", "p90", "p95", "p99", "p99.9", "Max"}
+
+// CSV returns the summary as CSV values, in the order of SummaryCSVHeader
+func (s Summary) CSV() []string {
+	ret := make([]string, 0, len(SummaryCSVHeader))
+	for _, v := range []float64{s.Min, s.Avg, s.StdDev, s.P50, s.P90, s.P95, s.P99, s.P999, s.Max} {
+		ret = append(ret, strconv.FormatFloat(v, 'f', 2, 64))
+	}
+	return ret
+}
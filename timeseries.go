@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	"github.com/RedisLabs/rsbench/stats"
+)
+
+// IntervalSample holds the benchmark results of a single reporting interval
+type IntervalSample struct {
+	Elapsed  float64       `json:"elapsed"`
+	Requests int           `json:"requests"`
+	Errors   int           `json:"errors"`
+	RPS      float64       `json:"rps"`
+	Latency  stats.Summary `json:"latency"`
+}
+
+// TimeSeriesWriter writes interval samples as CSV rows or JSON lines
+type TimeSeriesWriter struct {
+	cw  *csv.Writer
+	enc *json.Encoder
+}
+
+func NewTimeSeriesWriter(out io.Writer, asCSV bool) (*TimeSeriesWriter, error) {
+	if !asCSV {
+		return &TimeSeriesWriter{enc: json.NewEncoder(out)}, nil
+	}
+	ret := &TimeSeriesWriter{cw: csv.NewWriter(out)}
+	header := append([]string{
+		"Time Elapsed",
+		"Requests",
+		"Errors",
+		"Requests/Second",
+	}, stats.SummaryCSVHeader...)
+	if err := ret.cw.Write(header); err != nil {
+		return nil, err
+	}
+	ret.cw.Flush()
+	return ret, ret.cw.Error()
+}
+
+func (w *TimeSeriesWriter) Write(s IntervalSample) error {
+	if w.enc != nil {
+		return w.enc.Encode(s)
+	}
+	vals := append([]string{
+		strconv.FormatFloat(s.Elapsed, 'f', 2, 64),
+		strconv.Itoa(s.Requests),
+		strconv.Itoa(s.Errors),
+		strconv.FormatFloat(s.RPS, 'f', 2, 64),
+	}, s.Latency.CSV()...)
+	if err := w.cw.Write(vals); err != nil {
+		return err
+	}
+	w.cw.Flush()
+	return w.cw.Error()
+}
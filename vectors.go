@@ -0,0 +1,264 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/RedisLabs/rsbench/indexer"
+	"github.com/RedisLabs/rsbench/parser"
+)
+
+// vectorOpener returns the reader opener of a vector dataset format, reading vectors into field, or nil if the
+// format is not a vector format
+func vectorOpener(format, field string) indexer.DocumentReaderOpener {
+	switch format {
+	case "fvecs":
+		return parser.FvecsReaderOpener(field)
+	case "bvecs":
+		return parser.BvecsReaderOpener(field)
+	case "npy":
+		return parser.NpyReaderOpener(field)
+	case "vecjson":
+		return parser.VectorJSONReaderOpener(field)
+	}
+	return nil
+}
+
+// openVectors opens a vector dataset file
+func openVectors(path string, opener indexer.DocumentReaderOpener) (indexer.DocumentReader, func() error, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rd, err := opener.Open(fp)
+	if err != nil {
+		fp.Close()
+		return nil, nil, err
+	}
+	return rd, fp.Close, nil
+}
+
+// LoadVectors reads up to max vectors (all if max is 0) from the given field of the documents of rd
+func LoadVectors(rd indexer.DocumentReader, field string, max int) ([][]float32, error) {
+	ret := [][]float32{}
+	for max == 0 || len(ret) < max {
+		doc, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		vec, ok := doc.Properties[field].([]float32)
+		if !ok {
+			return nil, fmt.Errorf("document %s has no vector field %s", doc.Id, field)
+		}
+		ret = append(ret, vec)
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("no query vectors")
+	}
+	return ret, nil
+}
+
+// distance returns the distance between two vectors in the given metric, the way RediSearch computes it:
+// the squared euclidean distance for L2, and one minus the (normalized) inner product for IP and COSINE
+func distance(metric string, a, b []float32) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		x, y := float64(a[i]), float64(b[i])
+		switch metric {
+		case "L2":
+			dot += (x - y) * (x - y)
+		default:
+			dot += x * y
+			na += x * x
+			nb += y * y
+		}
+	}
+	switch metric {
+	case "L2":
+		return dot
+	case "COSINE":
+		if na == 0 || nb == 0 {
+			return 1
+		}
+		return 1 - dot/math.Sqrt(na*nb)
+	}
+	return 1 - dot
+}
+
+type neighbor struct {
+	id   string
+	dist float64
+}
+
+// neighbors is a max heap of the nearest neighbors found so far, farthest first
+type neighbors []neighbor
+
+func (h neighbors) Len() int            { return len(h) }
+func (h neighbors) Less(i, j int) bool  { return h[i].dist > h[j].dist }
+func (h neighbors) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *neighbors) Push(x interface{}) { *h = append(*h, x.(neighbor)) }
+func (h *neighbors) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func (h *neighbors) add(n neighbor, k int) {
+	if h.Len() < k {
+		heap.Push(h, n)
+	} else if n.dist < (*h)[0].dist {
+		(*h)[0] = n
+		heap.Fix(h, 0)
+	}
+}
+
+// sorted returns the ids of the neighbors, nearest first
+func (h *neighbors) sorted() []string {
+	ret := make([]string, h.Len())
+	for i := len(ret) - 1; i >= 0; i-- {
+		ret[i] = heap.Pop(h).(neighbor).id
+	}
+	return ret
+}
+
+// groundTruthBatch is the number of dataset vectors compared to all the queries at once
+const groundTruthBatch = 1024
+
+// ExactKNN computes the exact k nearest neighbors of every query vector by brute force over the documents
+// of the base vectors file, returning their ids, nearest first. The queries are spread over all CPUs
+func ExactKNN(basePath string, opener indexer.DocumentReaderOpener, field, metric string, queries [][]float32,
+	k int) ([][]string, error) {
+	if fi, err := os.Stat(basePath); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory, not a base vectors file", basePath)
+	}
+	rd, closer, err := openVectors(basePath, opener)
+	if err != nil {
+		return nil, err
+	}
+	defer closer()
+
+	heaps := make([]neighbors, len(queries))
+	workers := runtime.NumCPU()
+	batch := make([]neighbor, 0, groundTruthBatch)
+	vecs := make([][]float32, 0, groundTruthBatch)
+
+	flush := func() {
+		wg := sync.WaitGroup{}
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for q := w; q < len(queries); q += workers {
+					for i, vec := range vecs {
+						heaps[q].add(neighbor{batch[i].id, distance(metric, queries[q], vec)}, k)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		batch, vecs = batch[:0], vecs[:0]
+	}
+
+	for {
+		doc, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		vec, ok := doc.Properties[field].([]float32)
+		if !ok {
+			return nil, fmt.Errorf("document %s has no vector field %s", doc.Id, field)
+		}
+		batch = append(batch, neighbor{id: doc.Id})
+		vecs = append(vecs, vec)
+		if len(vecs) == groundTruthBatch {
+			flush()
+		}
+	}
+	flush()
+
+	ret := make([][]string, len(queries))
+	for q := range heaps {
+		ret[q] = heaps[q].sorted()
+	}
+	return ret, nil
+}
+
+// recall returns the fraction of the k nearest neighbors in truth that are in results
+func recall(results, truth []string, k int) float64 {
+	if k > len(truth) {
+		k = len(truth)
+	}
+	if k == 0 {
+		return 0
+	}
+	want := make(map[string]bool, k)
+	for _, id := range truth[:k] {
+		want[id] = true
+	}
+	found := 0
+	for _, id := range results {
+		if want[id] {
+			found++
+			delete(want, id)
+		}
+	}
+	return float64(found) / float64(k)
+}
+
+// loadVectorQueries reads up to n query vectors, and if k is set, their k nearest neighbors: from an ivecs ground
+// truth file if gtPath is set, or computed exactly from the base dataset file otherwise. The ground truth must
+// cover every query vector. Neighbor ids are prefixed with the key prefix the documents were written under, as
+// searches return them
+func loadVectorQueries(opener indexer.DocumentReaderOpener, queriesPath, field string, n, k int,
+	gtPath, basePath, metric, prefix string) ([][]float32, [][]string, error) {
+	rd, closer, err := openVectors(queriesPath, opener)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer closer()
+	vecs, err := LoadVectors(rd, field, n)
+	if err != nil || k == 0 {
+		return vecs, nil, err
+	}
+
+	var truth [][]string
+	if gtPath != "" {
+		fp, err := os.Open(gtPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer fp.Close()
+		if truth, err = parser.ReadGroundTruth(fp); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		if basePath == "" {
+			return nil, nil, fmt.Errorf("computing ground truth needs -vecgt, -vecbase or a vector -reader")
+		}
+		if truth, err = ExactKNN(basePath, opener, field, metric, vecs, k); err != nil {
+			return nil, nil, fmt.Errorf("computing ground truth: %s", err)
+		}
+	}
+	if len(truth) < len(vecs) {
+		return nil, nil, fmt.Errorf("the ground truth has %d rows, fewer than the %d query vectors", len(truth),
+			len(vecs))
+	}
+	for _, ids := range truth {
+		for i := range ids {
+			ids[i] = prefix + ids[i]
+		}
+	}
+	return vecs, truth, nil
+}
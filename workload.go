@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/RedisLabs/redisearch-go/redisearch"
+)
+
+// NumericFilterSpec is a numeric FILTER on a single field
+type NumericFilterSpec struct {
+	Field        string  `json:"field"`
+	Min          float64 `json:"min"`
+	ExclusiveMin bool    `json:"exclusive_min"`
+	Max          float64 `json:"max"`
+	ExclusiveMax bool    `json:"exclusive_max"`
+}
+
+// GeoFilterSpec is a GEOFILTER on a single field. It is ignored if Field is empty
+type GeoFilterSpec struct {
+	Field  string  `json:"field"`
+	Lon    float64 `json:"lon"`
+	Lat    float64 `json:"lat"`
+	Radius float64 `json:"radius"`
+	Unit   string  `json:"unit"`
+}
+
+// QuerySpec describes a single query shape of a benchmark workload
+type QuerySpec struct {
+	Name           string              `json:"name"`
+	Query          string              `json:"query"`
+	Weight         float64             `json:"weight"`
+	Offset         int                 `json:"offset"`
+	Limit          int                 `json:"limit"`
+	NoContent      bool                `json:"nocontent"`
+	Verbatim       bool                `json:"verbatim"`
+	WithScores     bool                `json:"withscores"`
+	InOrder        bool                `json:"inorder"`
+	Scorer         string              `json:"scorer"`
+	Language       string              `json:"language"`
+	Expander       string              `json:"expander"`
+	SortBy         string              `json:"sortby"`
+	SortDesc       bool                `json:"sortdesc"`
+	Return         []string            `json:"return"`
+	Highlight      []string            `json:"highlight"`
+	Summarize      []string            `json:"summarize"`
+	SummarizeFrags int                 `json:"summarize_frags"`
+	SummarizeLen   int                 `json:"summarize_len"`
+	InFields       []string            `json:"infields"`
+	Filters        []NumericFilterSpec `json:"filters"`
+	GeoFilter      GeoFilterSpec       `json:"geofilter"`
+	Aggregate      string              `json:"aggregate"`
+	WithCursor     bool                `json:"withcursor"`
+	CursorCount    int                 `json:"cursor_count"`
+	Suggest        string              `json:"suggest"`
+	Fuzzy          bool                `json:"fuzzy"`
+	Max            int                 `json:"max"`
+	KNN            int                 `json:"knn"`
+	VectorRange    float64             `json:"vector_range"`
+	VectorField    string              `json:"vector_field"`
+	EfRuntime      int                 `json:"ef_runtime"`
+}
+
+// DefaultQuerySpec is the query shape rsbench has always benchmarked
+var DefaultQuerySpec = QuerySpec{
+	Weight:         1,
+	Offset:         0,
+	Limit:          1,
+	NoContent:      true,
+	Verbatim:       true,
+	Scorer:         "DISMAX",
+	SummarizeFrags: 3,
+	SummarizeLen:   20,
+	VectorField:    "vec",
+}
+
+// clone returns a copy of the spec that shares no slices with the original
+func (s QuerySpec) clone() QuerySpec {
+	s.Return = append([]string(nil), s.Return...)
+	s.Highlight = append([]string(nil), s.Highlight...)
+	s.Summarize = append([]string(nil), s.Summarize...)
+	s.InFields = append([]string(nil), s.InFields...)
+	s.Filters = append([]NumericFilterSpec(nil), s.Filters...)
+	return s
+}
+
+// Build creates the redisearch query described by the spec
+func (s QuerySpec) Build() *redisearch.Query {
+	var flags redisearch.Flag
+	if s.NoContent {
+		flags |= redisearch.QueryNoContent
+	}
+	if s.Verbatim {
+		flags |= redisearch.QueryVerbatim
+	}
+	if s.WithScores {
+		flags |= redisearch.QueryWithScores
+	}
+	if s.InOrder {
+		flags |= redisearch.QueryInOrder
+	}
+	q := redisearch.NewQuery(s.Query).
+		SetFlags(flags).
+		Limit(s.Offset, s.Limit)
+	if s.Scorer != "" {
+		q.SetScorer(s.Scorer)
+	}
+	if s.Language != "" {
+		q.SetLanguage(s.Language)
+	}
+	if s.Expander != "" {
+		q.SetExpander(s.Expander)
+	}
+	if s.SortBy != "" {
+		q.SetSortBy(s.SortBy, !s.SortDesc)
+	}
+	if len(s.Return) > 0 {
+		q.SetReturnFields(s.Return...)
+	}
+	if len(s.Highlight) > 0 {
+		q.Highlight(s.Highlight, "<b>", "</b>")
+	}
+	if len(s.Summarize) > 0 {
+		q.SummarizeOptions(redisearch.SummaryOptions{
+			Fields:       s.Summarize,
+			FragmentLen:  s.SummarizeLen,
+			NumFragments: s.SummarizeFrags,
+			Separator:    "...",
+		})
+	}
+	if len(s.InFields) > 0 {
+		q.InFields = s.InFields
+	}
+	for _, f := range s.Filters {
+		q.AddFilter(redisearch.Filter{
+			Field: f.Field,
+			Options: redisearch.NumericFilterOptions{
+				Min:          f.Min,
+				ExclusiveMin: f.ExclusiveMin,
+				Max:          f.Max,
+				ExclusiveMax: f.ExclusiveMax,
+			},
+		})
+	}
+	if s.GeoFilter.Field != "" {
+		q.AddFilter(redisearch.Filter{
+			Field: s.GeoFilter.Field,
+			Options: redisearch.GeoFilterOptions{
+				Lon:    s.GeoFilter.Lon,
+				Lat:    s.GeoFilter.Lat,
+				Radius: s.GeoFilter.Radius,
+				Unit:   redisearch.Unit(s.GeoFilter.Unit),
+			},
+		})
+	}
+	return q
+}
+
+// IsAggregate returns true if the spec is an FT.AGGREGATE request rather than FT.SEARCH
+func (s QuerySpec) IsAggregate() bool {
+	return s.Aggregate != ""
+}
+
+// IsSuggest returns true if the spec is an FT.SUGGET request on the Suggest dictionary. Its query is used as a
+// fixed prefix, or if empty, prefixes are sampled from the terms added to the dictionary
+func (s QuerySpec) IsSuggest() bool {
+	return s.Suggest != ""
+}
+
+// IsVector returns true if the spec is a vector similarity search: the KNN nearest neighbors of a query vector,
+// or the documents within VectorRange of it. Its query, if set, prefilters the documents searched
+func (s QuerySpec) IsVector() bool {
+	return s.KNN > 0 || s.VectorRange > 0
+}
+
+// VectorName returns the default name of a vector search spec
+func (s QuerySpec) VectorName() string {
+	name := fmt.Sprintf("VECTOR_RANGE %g @%s", s.VectorRange, s.VectorField)
+	if s.KNN > 0 {
+		name = fmt.Sprintf("KNN %d @%s", s.KNN, s.VectorField)
+	}
+	if s.Query != "" {
+		name = s.Query + " " + name
+	}
+	return name
+}
+
+// VectorArgs returns the FT.SEARCH arguments of a vector search for the spec and the given query vector blob,
+// starting with the index name. Results are sorted by distance
+func (s QuerySpec) VectorArgs(index string, blob []byte) []interface{} {
+	filter := s.Query
+	if filter == "" {
+		filter = "*"
+	}
+	var query string
+	limit := s.Limit
+	if s.KNN > 0 {
+		ef := ""
+		if s.EfRuntime > 0 {
+			ef = fmt.Sprintf(" EF_RUNTIME %d", s.EfRuntime)
+		}
+		query = fmt.Sprintf("(%s)=>[KNN %d @%s $BLOB%s AS dist]", filter, s.KNN, s.VectorField, ef)
+		limit = s.KNN
+	} else {
+		query = fmt.Sprintf("@%s:[VECTOR_RANGE %g $BLOB]=>{$YIELD_DISTANCE_AS: dist}", s.VectorField, s.VectorRange)
+		if filter != "*" {
+			query = fmt.Sprintf("(%s) %s", filter, query)
+		}
+	}
+	args := []interface{}{index, query, "PARAMS", 2, "BLOB", blob, "SORTBY", "dist"}
+	if s.NoContent {
+		args = append(args, "NOCONTENT")
+	} else if len(s.Return) > 0 {
+		args = append(args, "RETURN", len(s.Return))
+		for _, f := range s.Return {
+			args = append(args, f)
+		}
+	}
+	return append(args, "LIMIT", s.Offset, limit, "DIALECT", 2)
+}
+
+// SuggestArgs returns the FT.SUGGET arguments for the spec and the given prefix
+func (s QuerySpec) SuggestArgs(prefix string) []interface{} {
+	args := []interface{}{s.Suggest, prefix}
+	if s.Fuzzy {
+		args = append(args, "FUZZY")
+	}
+	if s.WithScores {
+		args = append(args, "WITHSCORES")
+	}
+	if s.Max > 0 {
+		args = append(args, "MAX", s.Max)
+	}
+	return args
+}
+
+// AggregateArgs returns the FT.AGGREGATE arguments for the spec, starting with the index name
+func (s QuerySpec) AggregateArgs(index string) ([]interface{}, error) {
+	pipeline, err := SplitArgs(s.Aggregate)
+	if err != nil {
+		return nil, err
+	}
+	args := []interface{}{index, s.Query}
+	for _, a := range pipeline {
+		args = append(args, a)
+	}
+	if s.WithCursor {
+		args = append(args, "WITHCURSOR")
+		if s.CursorCount > 0 {
+			args = append(args, "COUNT", s.CursorCount)
+		}
+	}
+	return args, nil
+}
+
+// SplitArgs splits a command line into arguments on whitespace, like redis-cli does. Arguments containing
+// whitespace can be quoted with single or double quotes, e.g. APPLY "upper(@name)" AS name
+func SplitArgs(s string) ([]string, error) {
+	ret := []string{}
+	var cur []rune
+	var quote rune
+	inArg := false
+	for _, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur = append(cur, r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inArg = true
+		case unicode.IsSpace(r):
+			if inArg {
+				ret = append(ret, string(cur))
+				cur = cur[:0]
+				inArg = false
+			}
+		default:
+			cur = append(cur, r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unbalanced quotes in %q", s)
+	}
+	if inArg {
+		ret = append(ret, string(cur))
+	}
+	return ret, nil
+}
+
+// ParseList splits a comma separated list, returning nil for an empty string
+func ParseList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	ret := strings.Split(s, ",")
+	for i := range ret {
+		ret[i] = strings.TrimSpace(ret[i])
+	}
+	return ret
+}
+
+// parseRangeValue parses a numeric range boundary. A ( prefix makes it exclusive, like in the query syntax
+func parseRangeValue(s string) (v float64, exclusive bool, err error) {
+	if strings.HasPrefix(s, "(") {
+		exclusive = true
+		s = s[1:]
+	}
+	v, err = strconv.ParseFloat(s, 64)
+	return
+}
+
+// ParseNumericFilters parses a comma separated list of field:min:max numeric filters, e.g. "date:(1000:+inf"
+func ParseNumericFilters(s string) ([]NumericFilterSpec, error) {
+	ret := []NumericFilterSpec{}
+	for _, f := range ParseList(s) {
+		parts := strings.Split(f, ":")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("invalid numeric filter %q, expected field:min:max", f)
+		}
+		spec := NumericFilterSpec{Field: parts[0]}
+		var err error
+		if spec.Min, spec.ExclusiveMin, err = parseRangeValue(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid numeric filter %q: %s", f, err)
+		}
+		if spec.Max, spec.ExclusiveMax, err = parseRangeValue(parts[2]); err != nil {
+			return nil, fmt.Errorf("invalid numeric filter %q: %s", f, err)
+		}
+		ret = append(ret, spec)
+	}
+	return ret, nil
+}
+
+// ParseGeoFilter parses a field:lon:lat:radius:unit geo filter
+func ParseGeoFilter(s string) (GeoFilterSpec, error) {
+	if s == "" {
+		return GeoFilterSpec{}, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) != 5 {
+		return GeoFilterSpec{}, fmt.Errorf("invalid geo filter %q, expected field:lon:lat:radius:unit", s)
+	}
+	ret := GeoFilterSpec{Field: parts[0], Unit: parts[4]}
+	for i, v := range []*float64{&ret.Lon, &ret.Lat, &ret.Radius} {
+		var err error
+		if *v, err = strconv.ParseFloat(parts[i+1], 64); err != nil {
+			return GeoFilterSpec{}, fmt.Errorf("invalid geo filter %q: %s", s, err)
+		}
+	}
+	return ret, nil
+}
+
+// LoadWorkload reads a JSON lines workload, one QuerySpec per line. Fields missing from a line are taken
+// from defaults. Empty lines and lines starting with # are ignored
+func LoadWorkload(r io.Reader, defaults QuerySpec) ([]QuerySpec, error) {
+	ret := []QuerySpec{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		spec := defaults.clone()
+		if err := json.Unmarshal([]byte(line), &spec); err != nil {
+			return nil, fmt.Errorf("workload line %d: %s", n, err)
+		}
+		if spec.Query == "" && !spec.IsSuggest() && !spec.IsVector() {
+			return nil, fmt.Errorf("workload line %d: missing query", n)
+		}
+		if spec.Weight <= 0 {
+			return nil, fmt.Errorf("workload line %d: weight must be positive", n)
+		}
+		if spec.Name == "" {
+			spec.Name = spec.Query
+			if spec.IsSuggest() {
+				spec.Name = "FT.SUGGET " + spec.Suggest + " " + spec.Query
+			} else if spec.IsVector() {
+				spec.Name = spec.VectorName()
+			}
+		}
+		ret = append(ret, spec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("workload has no queries")
+	}
+	return ret, nil
+}
+
+func LoadWorkloadFile(path string, defaults QuerySpec) ([]QuerySpec, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	return LoadWorkload(fp, defaults)
+}